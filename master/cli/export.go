@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// 导出接口支持的过滤参数，和 /job/logs 相同
+var exportFilterParams = []string{
+	"jobName", "result", "exitCode", "signal", "stdout", "stderr", "lockLost", "worker", "text",
+	"planTimeStart", "planTimeEnd", "startTimeStart", "startTimeEnd", "minDuration", "maxDuration",
+}
+
+// Export 调用 master 的日志导出接口，把结果写到文件或标准输出
+// master export -addr http://127.0.0.1:10002 -username admin -password xxx -format csv -o logs.csv -jobName job1
+func Export(args []string) (err error) {
+	var (
+		flagSet  *flag.FlagSet
+		addr     string
+		token    string
+		username string
+		password string
+		format   string
+		outFile  string
+		filters  map[string]*string
+		form     url.Values
+		out      io.Writer
+		file     *os.File
+		resp     *http.Response
+		req      *http.Request
+	)
+
+	flagSet = flag.NewFlagSet("export", flag.ExitOnError)
+	flagSet.StringVar(&addr, "addr", "http://127.0.0.1:10002", "master 地址")
+	flagSet.StringVar(&token, "token", "", "登录后的 jwt_token，不填时使用用户名和密码登录")
+	flagSet.StringVar(&username, "username", "", "用户名")
+	flagSet.StringVar(&password, "password", "", "密码")
+	flagSet.StringVar(&format, "format", "csv", "导出格式 csv 或 jsonl")
+	flagSet.StringVar(&outFile, "o", "", "输出文件，不填时输出到标准输出")
+	filters = make(map[string]*string)
+	for _, param := range exportFilterParams {
+		filters[param] = flagSet.String(param, "", "过滤条件，同 /job/logs 的 "+param+" 参数")
+	}
+	flagSet.Parse(args)
+	addr = strings.TrimSuffix(addr, "/")
+
+	if token == "" {
+		if token, err = login(addr, username, password); err != nil {
+			return
+		}
+	}
+
+	form = url.Values{"format": {format}}
+	for param, value := range filters {
+		if *value != "" {
+			form.Set(param, *value)
+		}
+	}
+	if req, err = http.NewRequest(http.MethodPost, addr+"/job/logs/export", strings.NewReader(form.Encode())); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "jwt_token", Value: token})
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	// 参数错误、未登录等情况返回的是 JSON 而不是附件
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Disposition") == "" {
+		content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("导出失败: %s %s", resp.Status, string(content))
+	}
+
+	out = os.Stdout
+	if outFile != "" {
+		if file, err = os.Create(outFile); err != nil {
+			return
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		return
+	}
+
+	// trailer 在读取完响应体之后才可用
+	if exportErr := resp.Trailer.Get("X-Export-Error"); exportErr != "" {
+		return fmt.Errorf("导出中断，已导出 %s 条: %s", resp.Trailer.Get("X-Export-Rows"), exportErr)
+	}
+	fmt.Fprintf(os.Stderr, "导出完成，共 %s 条\n", resp.Trailer.Get("X-Export-Rows"))
+	return
+}
+
+// 使用用户名和密码登录，返回 jwt_token
+func login(addr string, username string, password string) (token string, err error) {
+	var (
+		resp    *http.Response
+		content []byte
+	)
+
+	if username == "" {
+		return "", errors.New("需要指定 -token 或 -username 和 -password")
+	}
+	if resp, err = http.PostForm(addr+"/user/login", url.Values{"username": {username}, "password": {password}}); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	// 登录接口设置的 cookie 绑定了域名，这里直接从响应中读取
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "jwt_token" {
+			return cookie.Value, nil
+		}
+	}
+	content, _ = ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return "", fmt.Errorf("登录失败: %s %s", resp.Status, string(content))
+}
@@ -6,6 +6,62 @@ var (
 		"单次任务": 1,
 	}
 
+	JobKind = map[string]int{
+		"命令任务": 0, // 通过 bash -c 执行 command
+		"脚本任务": 1, // 把脚本写入临时文件，使用指定的解释器执行
+		"内置任务": 2, // 调用 worker 中注册的 Go 函数
+	}
+
+	// LockLostPolicy 任务执行过程中锁丢失(续租失败，租约过期)后的处理策略
+	LockLostPolicy = map[string]int{
+		"标记": 0, // 继续执行，在执行日志中标记锁丢失
+		"强杀": 1, // 强杀任务，避免和其他 worker 上的同一任务同时执行
+	}
+
+	// Interpreters 脚本任务支持的解释器及默认的可执行文件
+	Interpreters = map[string]string{
+		"sh":      "sh",
+		"bash":    "bash",
+		"python3": "python3",
+		"perl":    "perl",
+	}
+
+	// EventReason 执行事件的原因
+	EventReason = map[string]string{
+		"开始执行": "started",   // 任务被调度执行
+		"跳过执行": "skipped",   // 上一次执行尚未结束，跳过本次计划
+		"抢锁失败": "lock_busy", // 任务正在其他 worker 上执行
+		"锁丢失":  "lock_lost", // 执行过程中任务锁丢失
+		"被强杀":  "killed",    // 任务被强杀
+		"执行超时": "timeout",   // 任务执行超时被结束
+		"执行失败": "failed",    // 任务执行出错或不满足成功条件
+		"执行成功": "succeeded", // 任务执行成功
+	}
+
+	// ReconcileKind mysql 与 etcd 中任务不一致的类型
+	ReconcileKind = map[string]string{
+		"孤立任务":  "orphan",       // etcd 中有任务，mysql 中没有
+		"残留任务":  "stale_etcd",   // mysql 中任务已删除或单次任务已结束，etcd 中仍然存在
+		"缺失任务":  "missing",      // mysql 中任务可以调度，etcd 中不存在
+		"状态过期":  "stale_status", // mysql 中的任务状态与实际不符
+		"配置不一致": "drift",        // mysql 与 etcd 中的任务配置不同
+		"无匹配节点": "unplaceable",  // 没有在线 worker 满足任务的节点选择器
+	}
+
+	// MutationOp 任务变更类型
+	MutationOp = map[string]int{
+		"保存": 0, // 新增或修改任务，写入 /cron/jobs/
+		"删除": 1, // 从 /cron/jobs/ 删除
+		"强杀": 2, // 写入 /cron/killer/ 通知 worker 强杀
+	}
+
+	// MutationStatus 任务变更的发布状态
+	MutationStatus = map[string]int{
+		"待发布":  0,
+		"已发布":  1,
+		"发布失败": 2, // 重试超过次数上限
+	}
+
 	StatusTyp = map[string]int{
 		"待调度":  0, // 任务在 etcd 中，还没有通过 watch 放入计划表
 		"待执行":  1, // 任务已同步至计划表
@@ -28,4 +84,47 @@ const (
 
 	// JobWorkerDir 服务注册目录
 	JobWorkerDir = "/cron/workers/"
+
+	// LogTimeLayout 接口中时间参数的格式
+	LogTimeLayout = "2006/01/02 15:04:05"
+
+	// ExportTimeLayout 导出日志中时间字段的格式，保留毫秒
+	ExportTimeLayout = "2006/01/02 15:04:05.000"
+
+	// LogCollection mongodb 中的日志集合
+	LogCollection = "log"
+
+	// JobReportDir worker 通过 etcd 上报的任务状态、执行事件和执行日志，master 写入数据库后删除
+	JobReportDir = "/cron/reports/"
+
+	// JobReportFailedDir 无法解析或多次写入数据库失败的上报记录，不在 JobReportDir 下，不会被重新消费
+	JobReportFailedDir = "/cron/failed_reports/"
+
+	// SpoolTypLog worker 上报的日志记录
+	SpoolTypLog = "log"
+	// SpoolTypStatus worker 上报的任务状态记录
+	SpoolTypStatus = "status"
+	// SpoolTypEvent worker 上报的执行事件记录
+	SpoolTypEvent = "event"
+
+	// JobMutationDir 已经发布到 etcd 的任务变更，发布时用于去重，一天后自动过期
+	JobMutationDir = "/cron/mutations/"
+
+	// WorkerLogLevelKey worker 的日志级别，修改后所有 worker 立即生效，删除后恢复为配置文件中的级别
+	WorkerLogLevelKey = "/cron/log_level"
+
+	// JobSecretDir 密钥目录，只保存密文
+	JobSecretDir = "/cron/secrets/"
+
+	// JobOutputDir 任务实时输出目录(redis list)
+	JobOutputDir = "/cron/output/"
+
+	// JobOutputStateDir 任务实时输出状态目录(redis string)
+	JobOutputStateDir = "/cron/output_state/"
+
+	// JobOutputRunning 任务正在输出
+	JobOutputRunning = "running"
+
+	// JobOutputDone 任务输出结束
+	JobOutputDone = "done"
 )
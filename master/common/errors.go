@@ -3,5 +3,20 @@ package common
 import "errors"
 
 var (
-	ErrNoLocalIpFound      = errors.New("没有找到网卡IP")
+	ErrNoLocalIpFound     = errors.New("没有找到网卡IP")
+	ErrStorageTypUnknown  = errors.New("不支持的存储类型")
+	ErrInterpreterUnknown = errors.New("不支持的脚本解释器")
+	ErrScriptEmpty        = errors.New("脚本内容不能为空")
+	ErrTaskEmpty          = errors.New("内置任务名不能为空")
+	ErrTaskParams         = errors.New("内置任务参数不是合法的 JSON")
+	ErrLogSinkUnknown     = errors.New("不支持的日志存储类型")
+	ErrLogSinkNotReadable = errors.New("主日志存储不支持查询")
+	ErrSecretKeyEmpty     = errors.New("没有配置密钥加密 key")
+	ErrSecretCorrupted    = errors.New("密钥密文已损坏")
+	ErrSuccessCodes       = errors.New("成功退出码只能是逗号分隔的整数")
+	ErrSecretName         = errors.New("密钥名只能包含字母、数字和下划线，且不能以数字开头")
+	ErrArtifactWorkDir    = errors.New("收集产物的任务必须设置工作目录")
+	ErrSecretReserved     = errors.New("密钥名不能使用 PATH、HOME、LD_*、CRONTAB_* 等保留的环境变量名")
+	ErrPurgeRunning       = errors.New("日志清理正在执行")
+	ErrLogNotFound        = errors.New("执行日志不存在")
 )
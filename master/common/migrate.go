@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"crontab/master/logger"
+	"crontab/master/model"
+)
+
+// 日志表中从字符串迁移为 DATETIME(3) 的时间字段，旧数据为 worker 本地时区的 2006/01/02 15:04:05 格式
+var logTimeColumns = []string{"plan_time", "schedule_time", "start_time", "end_time", "lock_lost_time"}
+
+// 迁移时每批更新的行数
+const migrateBatchSize = 5000
+
+// migrateLogs 迁移旧版本的日志表，需要在 AutoMigrate 之前执行；master 和 worker 启动时都会执行，通过 mysql 命名锁保证只有一个节点迁移
+func migrateLogs(db *gorm.DB) (err error) {
+	var (
+		sqlDB       *sql.DB
+		conn        *sql.Conn
+		locked      sql.NullInt64
+		columnTypes []gorm.ColumnType
+		typeMap     map[string]string
+	)
+
+	if !db.Migrator().HasTable(&model.Log{}) {
+		return
+	}
+
+	// 命名锁属于连接，加锁和解锁需要使用同一个连接
+	if sqlDB, err = db.DB(); err != nil {
+		return
+	}
+	if conn, err = sqlDB.Conn(context.TODO()); err != nil {
+		return
+	}
+	defer conn.Close()
+	if err = conn.QueryRowContext(context.TODO(), "SELECT GET_LOCK('crontab_migrate_logs', 600)").Scan(&locked); err != nil {
+		return
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("等待日志表迁移锁超时")
+	}
+	defer conn.ExecContext(context.TODO(), "SELECT RELEASE_LOCK('crontab_migrate_logs')")
+
+	if columnTypes, err = db.Migrator().ColumnTypes(&model.Log{}); err != nil {
+		return
+	}
+	typeMap = make(map[string]string)
+	for _, columnType := range columnTypes {
+		typeMap[columnType.Name()] = strings.ToLower(columnType.DatabaseTypeName())
+	}
+
+	// 时间字段转换为 DATETIME(3)
+	for _, column := range logTimeColumns {
+		if typ, ok := typeMap[column]; !ok || typ == "datetime" {
+			continue
+		}
+		logger.Info.Printf("迁移日志表字段 %s 为 DATETIME(3) ", column)
+		if err = migrateLogTimeColumn(db, column, typeMap); err != nil {
+			return fmt.Errorf("迁移日志表字段 %s 失败: %s", column, err)
+		}
+	}
+
+	// 旧版本按默认命名创建了 max_rsskb 字段
+	if _, ok := typeMap["max_rsskb"]; ok {
+		if _, ok = typeMap["max_rss_kb"]; !ok {
+			if err = db.Migrator().RenameColumn(&model.Log{}, "max_rsskb", "max_rss_kb"); err != nil {
+				return
+			}
+		}
+	}
+
+	// 旧日志没有执行 id，使用日志 id 生成唯一的执行 id，之后由 AutoMigrate 创建唯一索引
+	if _, ok := typeMap["execution_id"]; !ok {
+		if err = db.Migrator().AddColumn(&model.Log{}, "ExecutionID"); err != nil {
+			return
+		}
+	}
+	if err = db.Exec("UPDATE logs SET execution_id = CONCAT('legacy-', id) WHERE execution_id IS NULL OR execution_id = ''").Error; err != nil {
+		return
+	}
+	if db.Migrator().HasIndex(&model.Log{}, "idx_logs_execution_id") {
+		err = db.Migrator().DropIndex(&model.Log{}, "idx_logs_execution_id")
+	}
+	return
+}
+
+// 把一个字符串时间字段迁移为 DATETIME(3)：新建临时字段，分批转换后替换原字段；无法解析的值使用日志的创建时间
+func migrateLogTimeColumn(db *gorm.DB, column string, typeMap map[string]string) (err error) {
+	var (
+		tmpColumn string
+		maxID     sql.NullInt64
+		nullable  string
+	)
+
+	tmpColumn = column + "_dt"
+	if _, ok := typeMap[tmpColumn]; !ok {
+		if err = db.Exec(fmt.Sprintf("ALTER TABLE logs ADD COLUMN %s DATETIME(3) NULL", tmpColumn)).Error; err != nil {
+			return
+		}
+	}
+
+	if err = db.Raw("SELECT MAX(id) FROM logs").Scan(&maxID).Error; err != nil {
+		return
+	}
+	for start := int64(0); start <= maxID.Int64; start += migrateBatchSize {
+		if err = db.Exec(fmt.Sprintf(
+			"UPDATE logs SET %s = STR_TO_DATE(%s, '%%Y/%%m/%%d %%H:%%i:%%s') "+
+				"WHERE id > ? AND id <= ? AND %s IS NULL AND %s REGEXP '^[0-9]{4}/[0-9]{2}/[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}$'",
+			tmpColumn, column, tmpColumn, column,
+		), start, start+migrateBatchSize).Error; err != nil {
+			return
+		}
+	}
+
+	// 锁丢失时间允许为空，其他时间字段使用创建时间填充
+	nullable = "NULL"
+	if column != "lock_lost_time" {
+		if err = db.Exec(fmt.Sprintf("UPDATE logs SET %s = COALESCE(created_at, NOW(3)) WHERE %s IS NULL", tmpColumn, tmpColumn)).Error; err != nil {
+			return
+		}
+		nullable = "NOT NULL"
+	}
+
+	return db.Exec(fmt.Sprintf(
+		"ALTER TABLE logs DROP COLUMN %s, CHANGE COLUMN %s %s DATETIME(3) %s",
+		column, tmpColumn, column, nullable,
+	)).Error
+}
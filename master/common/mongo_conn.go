@@ -29,6 +29,26 @@ func (_self *MongoMgr) Find(collection string, filter interface{}, limit int, sk
 	return c.Find(filter).Limit(limit).Skip(skip).Sort("-_id").All(result)
 }
 
+func (_self *MongoMgr) Count(collection string, filter interface{}) (int, error) {
+	ms, c := _self.connect(collection)
+	defer ms.Close()
+
+	return c.Find(filter).Count()
+}
+
+// Iter 按 _id 倒序使用游标遍历查询结果，每次从服务端读取 batchSize 条
+func (_self *MongoMgr) Iter(collection string, filter interface{}, batchSize int, fn func(iter *mgo.Iter) error) (err error) {
+	ms, c := _self.connect(collection)
+	defer ms.Close()
+
+	iter := c.Find(filter).Sort("-_id").Batch(batchSize).Iter()
+	if err = fn(iter); err != nil {
+		iter.Close()
+		return
+	}
+	return iter.Close()
+}
+
 func InitMongoConn() (err error) {
 
 	var (
@@ -66,12 +66,35 @@ func InitMySQLConn() (err error) {
 	// SetConnMaxLifetime 设置了连接可复用的最大时间
 	sqlDB.SetConnMaxLifetime(time.Duration(GConfig.MySQL.MaxLifetime) * time.Second)
 
+	// 迁移旧版本的日志表
+	if err = migrateLogs(db); err != nil {
+		logger.Error.Printf("日志表迁移失败: %s ", err)
+		return
+	}
+
 	db.AutoMigrate(&model.User{})
 	db.AutoMigrate(&model.Job{})
 	db.AutoMigrate(&model.Log{})
+	db.AutoMigrate(&model.JobScript{})
+	db.AutoMigrate(&model.Secret{})
+	db.AutoMigrate(&model.ExecutionEvent{})
+	db.AutoMigrate(&model.JobMutation{})
+	db.AutoMigrate(&model.SpoolKey{})
 
 	GMsql = &MySQLMgr{
 		DB: db,
 	}
 	return
 }
+
+// Ping 检查数据库是否可以连接
+func (_self *MySQLMgr) Ping() (err error) {
+	var (
+		sqlDB *sql.DB
+	)
+
+	if sqlDB, err = _self.DB.DB(); err != nil {
+		return
+	}
+	return sqlDB.Ping()
+}
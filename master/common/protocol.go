@@ -18,11 +18,27 @@ type UserDto struct {
 
 // Job 定时任务
 type Job struct {
-	Name     string `json:"name"`     //  任务名
-	Command  string `json:"command"`  // shell命令
-	CronExpr string `json:"cronExpr"` // cron表达式
-	Typ      int    `json:"typ"`      // 任务类型
-	Num      int    `json:"num"`      // 执行次数
+	Name           string   `json:"name"`           //  任务名
+	Command        string   `json:"command"`        // shell命令
+	CronExpr       string   `json:"cronExpr"`       // cron表达式
+	Typ            int      `json:"typ"`            // 任务类型
+	Num            int      `json:"num"`            // 执行次数
+	OutputLimit    int      `json:"outputLimit"`    // 输出大小上限(字节)，0 表示使用全局配置
+	Timeout        int      `json:"timeout"`        // 执行超时时间(秒)，0 表示不限制
+	Kind           int      `json:"kind"`           // 执行方式(0: 命令任务；1: 脚本任务；2: 内置任务)
+	Interpreter    string   `json:"interpreter"`    // 脚本解释器
+	Script         string   `json:"script"`         // 脚本内容
+	ScriptVersion  int      `json:"scriptVersion"`  // 脚本版本
+	Task           string   `json:"task"`           // 内置任务名
+	Params         string   `json:"params"`         // 内置任务的 JSON 参数
+	Secrets        []string `json:"secrets"`        // 引用的密钥名，执行时作为同名环境变量注入
+	SuccessCodes   string   `json:"successCodes"`   // 成功退出码，逗号分隔，为空时只有 0 表示成功
+	SuccessMatch   string   `json:"successMatch"`   // 输出必须匹配的正则
+	FailMatch      string   `json:"failMatch"`      // 输出不能匹配的正则
+	WorkDir        string   `json:"workDir"`        // 工作目录，为空时使用 worker 的工作目录
+	Artifacts      []string `json:"artifacts"`      // 产物文件的 glob，相对于工作目录
+	LockLostPolicy int      `json:"lockLostPolicy"` // 锁丢失后的处理策略(0: 标记；1: 强杀)
+	Selector       string   `json:"selector"`       // 节点选择器，见 Selector，为空时所有 worker 都可以执行
 }
 
 // JobEvent 变化事件
@@ -40,20 +56,37 @@ type JobSchedulePlan struct {
 
 // JobExecuteInfo 任务执行状态
 type JobExecuteInfo struct {
-	Job        *Job               // 任务信息
-	PlanTime   time.Time          // 理论上的调度时间
-	RealTime   time.Time          // 实际的调度时间
-	CancelCtx  context.Context    // 任务command的context
-	CancelFunc context.CancelFunc //  用于取消command执行的cancel函数
+	Job         *Job               // 任务信息
+	ExecutionID string             // 执行 id，每次执行唯一
+	PlanTime    time.Time          // 理论上的调度时间
+	RealTime    time.Time          // 实际的调度时间
+	CancelCtx   context.Context    // 任务command的context
+	CancelFunc  context.CancelFunc //  用于取消command执行的cancel函数
+}
+
+// CommandVars 命令模板变量，如 {{.PlanTime.Format "20060102"}}、{{.JobName}}
+type CommandVars struct {
+	PlanTime    time.Time // 计划调度时间
+	JobName     string    // 任务名
+	WorkerIP    string    // 执行任务的 worker
+	ExecutionID string    // 执行 id
 }
 
 // JobExecuteResult 任务执行结果
 type JobExecuteResult struct {
-	ExecuteInfo *JobExecuteInfo // 执行状态
-	Output      []byte          // 脚本输出
-	Err         error           // 脚本错误原因
-	StartTime   time.Time       // 启动时间
-	EndTime     time.Time       // 结束时间
+	ExecuteInfo     *JobExecuteInfo // 执行状态
+	Command         string          // 实际执行的命令(模板渲染之后)
+	Output          []byte          // 脚本输出(stdout 与 stderr 合并)
+	Stdout          []byte          // 标准输出
+	Stderr          []byte          // 标准错误输出
+	ExitCode        int             // 进程退出码
+	Signal          string          // 终止进程的信号
+	OutputSize      int64           // 完整输出的大小
+	OutputTruncated bool            // 输出是否被截断
+	OutputFile      string          // 截断时完整输出保存的文件
+	Err             error           // 脚本错误原因
+	StartTime       time.Time       // 启动时间
+	EndTime         time.Time       // 结束时间
 }
 
 // JobStatusEvent 任务状态更新
@@ -64,6 +97,74 @@ type JobStatusEvent struct {
 	NextTime  time.Time
 }
 
+// WorkerInfo worker 注册到 /cron/workers/IP 的节点信息，定时刷新
+type WorkerInfo struct {
+	IP         string            `json:"ip"`
+	WorkerID   string            `json:"workerId"`
+	Hostname   string            `json:"hostname"`
+	Version    string            `json:"version"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	CPUs       int               `json:"cpus"`
+	Memory     uint64            `json:"memory"` // 物理内存(字节)，无法获取时为 0
+	Labels     map[string]string `json:"labels"`
+	StartTime  time.Time         `json:"startTime"`
+	ActiveTime time.Time         `json:"activeTime"` // 最近一次刷新的时间
+	Running    int               `json:"running"`    // 正在执行的任务数
+}
+
+// JobOutputChunk 任务实时输出片段
+type JobOutputChunk struct {
+	Seq    int64  `json:"seq"`    // 本轮执行中的片段序号，从 0 开始
+	Stream string `json:"stream"` // 输出流 stdout / stderr
+	Data   string `json:"data"`   // 输出内容
+}
+
+// JobOutputState 任务实时输出状态
+type JobOutputState struct {
+	Status    string `json:"status"`    // running / done
+	StartTime int64  `json:"startTime"` // 本次执行开始时间(毫秒)，用于区分不同轮次的执行
+}
+
+// LogFilter 执行日志查询条件，指针字段为 nil 时表示不过滤
+type LogFilter struct {
+	JobName     string // 任务名
+	ExecutionID string // 执行 id
+	ExitCode    *int   // 退出码
+	Signal      string // 终止信号
+	Stdout      string // 标准输出包含的内容
+	Stderr      string // 标准错误输出包含的内容
+	LockLost    *bool  // 是否丢失了任务锁
+
+	Result         string     // 执行结果，0 表示执行出错；1 表示执行成功
+	PlanTimeStart  *time.Time // 计划开始时间范围
+	PlanTimeEnd    *time.Time
+	StartTimeStart *time.Time // 实际开始时间范围
+	StartTimeEnd   *time.Time
+	Worker         string // 执行任务的 worker IP 或标识
+	MinDurationMs  *int64 // 执行耗时范围(毫秒)
+	MaxDurationMs  *int64
+	Text           string // 输出或错误信息中包含的文本
+}
+
+// SpoolRecord worker 上报的一次日志、任务状态或执行事件写入，与 worker spool 中的记录格式相同
+type SpoolRecord struct {
+	Key    string                `json:"key"` // 幂等 key，已经写入过的记录会被跳过
+	Typ    string                `json:"typ"` // log / status / event
+	Log    *model.Log            `json:"log,omitempty"`
+	Status *SpoolStatus          `json:"status,omitempty"`
+	Event  *model.ExecutionEvent `json:"event,omitempty"`
+}
+
+// SpoolStatus 任务状态写入
+type SpoolStatus struct {
+	JobName   string    `json:"jobName"`
+	JobTyp    int       `json:"jobTyp"` // 任务类型(0: 定时任务；1: 单次任务)
+	StatusTyp int       `json:"statusTyp"`
+	AddNum    bool      `json:"addNum"`
+	NextTime  time.Time `json:"nextTime"`
+}
+
 // LogBatch 日志批次
 type LogBatch struct {
 	Logs []*model.Log // 多条日志
@@ -85,3 +186,11 @@ type Response struct {
 	Msg   string      `json:"msg"`
 	Data  interface{} `json:"data"`
 }
+
+// JobArtifact 任务产物
+type JobArtifact struct {
+	Name string `json:"name"`          // 相对于工作目录的文件路径
+	Key  string `json:"key"`           // 产物存储中的 key
+	Size int64  `json:"size"`          // 文件大小(字节)
+	Err  string `json:"err,omitempty"` // 上传失败的原因
+}
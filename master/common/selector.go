@@ -0,0 +1,162 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	selectorSetRegexp   = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+	selectorLabelRegexp = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// Selector 任务的节点选择器，节点标签满足所有条件时才能执行该任务，为空时匹配所有节点
+//
+// 条件之间用逗号分隔，支持：
+// zone=bj、zone==bj、zone!=bj、zone in (bj,sh)、zone notin (bj,sh)、gpu(存在该标签)、!gpu(不存在该标签)
+type Selector []SelectorRequirement
+
+// SelectorRequirement 选择器中的一个条件
+type SelectorRequirement struct {
+	Key    string
+	Op     string // = / != / in / notin / exists / !exists
+	Values []string
+}
+
+// ParseSelector 解析节点选择器
+func ParseSelector(expr string) (selector Selector, err error) {
+	var (
+		requirement SelectorRequirement
+	)
+
+	for _, term := range splitSelector(expr) {
+		if term = strings.TrimSpace(term); term == "" {
+			continue
+		}
+		if requirement, err = parseRequirement(term); err != nil {
+			return
+		}
+		selector = append(selector, requirement)
+	}
+	return
+}
+
+// 按括号外的逗号拆分条件
+func splitSelector(expr string) (terms []string) {
+	var (
+		depth int
+		start int
+	)
+
+	for i, c := range expr {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, expr[start:])
+	return
+}
+
+// 解析一个条件
+func parseRequirement(term string) (requirement SelectorRequirement, err error) {
+	var (
+		match []string
+		pair  []string
+	)
+
+	switch {
+	case selectorSetRegexp.MatchString(term):
+		match = selectorSetRegexp.FindStringSubmatch(term)
+		requirement = SelectorRequirement{Key: match[1], Op: match[2]}
+		for _, value := range strings.Split(match[3], ",") {
+			requirement.Values = append(requirement.Values, strings.TrimSpace(value))
+		}
+	case strings.HasPrefix(term, "!") && !strings.Contains(term, "="):
+		requirement = SelectorRequirement{Key: strings.TrimSpace(term[1:]), Op: "!exists"}
+	case strings.Contains(term, "!="):
+		pair = strings.SplitN(term, "!=", 2)
+		requirement = SelectorRequirement{Key: strings.TrimSpace(pair[0]), Op: "!=", Values: []string{strings.TrimSpace(pair[1])}}
+	case strings.Contains(term, "="):
+		pair = strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+		requirement = SelectorRequirement{Key: strings.TrimSpace(pair[0]), Op: "=", Values: []string{strings.TrimSpace(pair[1])}}
+	default:
+		requirement = SelectorRequirement{Key: term, Op: "exists"}
+	}
+
+	if !selectorLabelRegexp.MatchString(requirement.Key) {
+		err = fmt.Errorf("节点选择器格式错误: %s", term)
+		return
+	}
+	for _, value := range requirement.Values {
+		if !selectorLabelRegexp.MatchString(value) {
+			err = fmt.Errorf("节点选择器格式错误: %s", term)
+			return
+		}
+	}
+	return
+}
+
+// Matches 节点标签是否满足选择器
+func (_self Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range _self {
+		if !requirement.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (_self SelectorRequirement) matches(labels map[string]string) bool {
+	value, ok := labels[_self.Key]
+
+	switch _self.Op {
+	case "exists":
+		return ok
+	case "!exists":
+		return !ok
+	case "=", "in":
+		return ok && containsString(_self.Values, value)
+	case "!=", "notin":
+		return !ok || !containsString(_self.Values, value)
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// String 选择器的规范写法
+func (_self Selector) String() string {
+	var (
+		terms []string
+	)
+
+	for _, requirement := range _self {
+		switch requirement.Op {
+		case "exists":
+			terms = append(terms, requirement.Key)
+		case "!exists":
+			terms = append(terms, "!"+requirement.Key)
+		case "in", "notin":
+			terms = append(terms, fmt.Sprintf("%s %s (%s)", requirement.Key, requirement.Op, strings.Join(requirement.Values, ",")))
+		default:
+			terms = append(terms, requirement.Key+requirement.Op+requirement.Values[0])
+		}
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,262 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	GStorage         Storage
+	GArtifactStorage Storage
+)
+
+// Storage 文件存储，用于保存超长的任务输出等文件
+type Storage interface {
+	Put(key string, reader io.Reader, size int64) error // 保存文件
+	Get(key string) (io.ReadCloser, error)              // 读取文件
+	Delete(key string) error                            // 删除文件
+}
+
+// localStorage 本地目录存储，多个节点之间需要挂载同一个共享目录
+type localStorage struct {
+	dir string
+}
+
+// 文件在本地目录中的路径，避免 key 跳出存储目录
+func (_self *localStorage) filePath(key string) string {
+	return filepath.Join(_self.dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (_self *localStorage) Put(key string, reader io.Reader, size int64) (err error) {
+	var (
+		filePath string
+		file     *os.File
+	)
+
+	filePath = _self.filePath(key)
+	if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return
+	}
+	if file, err = os.Create(filePath); err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return
+}
+
+func (_self *localStorage) Get(key string) (io.ReadCloser, error) {
+	return os.Open(_self.filePath(key))
+}
+
+func (_self *localStorage) Delete(key string) (err error) {
+	if err = os.Remove(_self.filePath(key)); os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// s3Storage 兼容 S3 协议的对象存储(AWS S3、MinIO 等)，使用 AWS Signature V4 签名
+type s3Storage struct {
+	conf   StorageConf
+	client *http.Client
+}
+
+// 对象的 url
+func (_self *s3Storage) objectURL(key string) string {
+	var (
+		scheme   string
+		segments []string
+	)
+
+	scheme = "http"
+	if _self.conf.UseSSL {
+		scheme = "https"
+	}
+
+	segments = strings.Split(strings.TrimPrefix(key, "/"), "/")
+	for i := range segments {
+		segments[i] = s3Escape(segments[i])
+	}
+
+	if _self.conf.PathStyle {
+		return fmt.Sprintf("%s://%s/%s/%s", scheme, _self.conf.Endpoint, _self.conf.Bucket, strings.Join(segments, "/"))
+	}
+	return fmt.Sprintf("%s://%s.%s/%s", scheme, _self.conf.Bucket, _self.conf.Endpoint, strings.Join(segments, "/"))
+}
+
+// 请求签名
+func (_self *s3Storage) sign(req *http.Request) {
+	var (
+		now              time.Time
+		amzDate          string
+		scope            string
+		payloadHash      string
+		signedHeaders    string
+		canonicalRequest string
+		stringToSign     string
+		signingKey       []byte
+	)
+
+	now = time.Now().UTC()
+	amzDate = now.Format("20060102T150405Z")
+	scope = now.Format("20060102") + "/" + _self.conf.Region + "/s3/aws4_request"
+	payloadHash = "UNSIGNED-PAYLOAD"
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest = strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" + "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign = strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey = hmacSHA256([]byte("AWS4"+_self.conf.SecretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, _self.conf.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		_self.conf.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign)),
+	))
+}
+
+// 发送请求，非 2xx 响应作为错误返回
+func (_self *s3Storage) do(method string, key string, body io.Reader, size int64) (resp *http.Response, err error) {
+	var (
+		req     *http.Request
+		content []byte
+	)
+
+	if req, err = http.NewRequest(method, _self.objectURL(key), body); err != nil {
+		return
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	_self.sign(req)
+
+	if resp, err = _self.client.Do(req); err != nil {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		content, _ = ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		err = fmt.Errorf("对象存储请求失败: %s %s", resp.Status, string(content))
+		resp = nil
+	}
+	return
+}
+
+func (_self *s3Storage) Put(key string, reader io.Reader, size int64) (err error) {
+	var (
+		resp *http.Response
+	)
+
+	if resp, err = _self.do(http.MethodPut, key, reader, size); err != nil {
+		return
+	}
+	return resp.Body.Close()
+}
+
+func (_self *s3Storage) Get(key string) (reader io.ReadCloser, err error) {
+	var (
+		resp *http.Response
+	)
+
+	if resp, err = _self.do(http.MethodGet, key, nil, 0); err != nil {
+		return
+	}
+	reader = resp.Body
+	return
+}
+
+func (_self *s3Storage) Delete(key string) (err error) {
+	var (
+		resp *http.Response
+	)
+
+	if resp, err = _self.do(http.MethodDelete, key, nil, 0); err != nil {
+		return
+	}
+	return resp.Body.Close()
+}
+
+// s3Escape 按照 S3 签名规则对路径片段进行编码，只保留非保留字符
+func s3Escape(segment string) string {
+	var (
+		builder strings.Builder
+		c       byte
+	)
+
+	for i := 0; i < len(segment); i++ {
+		c = segment[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// 根据配置创建文件存储
+func newStorage(conf StorageConf) (storage Storage, err error) {
+	switch conf.Typ {
+	case "", "local":
+		storage = &localStorage{dir: conf.LocalDir}
+	case "s3":
+		storage = &s3Storage{
+			conf:   conf,
+			client: &http.Client{},
+		}
+	default:
+		err = ErrStorageTypUnknown
+	}
+	return
+}
+
+// InitStorage 初始化文件存储和任务产物存储
+func InitStorage() (err error) {
+	if GStorage, err = newStorage(GConfig.Storage); err != nil {
+		return
+	}
+
+	// 没有单独配置产物存储时，产物和完整输出保存在同一个存储中
+	if GConfig.ArtifactStorage.Typ == "" && GConfig.ArtifactStorage.LocalDir == "" {
+		GArtifactStorage = GStorage
+		return
+	}
+	GArtifactStorage, err = newStorage(GConfig.ArtifactStorage)
+	return
+}
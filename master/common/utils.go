@@ -2,7 +2,13 @@ package common
 
 import (
 	"crontab/master/model"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
 // ToUserDto 登录用户的响应信息
@@ -18,3 +24,114 @@ func ToUserDto(user model.User) UserDto {
 func ExtractWorkerIP(regKey string) string {
 	return strings.TrimPrefix(regKey, JobWorkerDir)
 }
+
+// RenderCommand 渲染命令模板，不包含模板语法的命令原样返回
+func RenderCommand(command string, vars *CommandVars) (rendered string, err error) {
+	var (
+		tmpl *template.Template
+		buf  strings.Builder
+	)
+
+	if !strings.Contains(command, "{{") {
+		rendered = command
+		return
+	}
+
+	if tmpl, err = template.New("command").Option("missingkey=error").Parse(command); err != nil {
+		return
+	}
+	if err = tmpl.Execute(&buf, vars); err != nil {
+		return
+	}
+	rendered = buf.String()
+	return
+}
+
+// CheckCommand 校验命令模板，使用示例变量渲染一次，引用了不存在的变量时返回错误
+func CheckCommand(command string) (err error) {
+	_, err = RenderCommand(command, &CommandVars{
+		PlanTime:    time.Now(),
+		JobName:     "job",
+		WorkerIP:    "127.0.0.1",
+		ExecutionID: "execution",
+	})
+	return
+}
+
+// ParseSuccessCodes 解析成功退出码，逗号分隔，为空时只有 0 表示成功
+func ParseSuccessCodes(codes string) (codeMap map[int]bool, err error) {
+	var (
+		code int
+	)
+
+	codeMap = make(map[int]bool)
+	for _, item := range strings.Split(codes, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if code, err = strconv.Atoi(item); err != nil {
+			err = ErrSuccessCodes
+			return
+		}
+		codeMap[code] = true
+	}
+	if len(codeMap) == 0 {
+		codeMap[0] = true
+	}
+	return
+}
+
+// CheckSuccessRule 校验任务的成功条件
+func CheckSuccessRule(successCodes string, successMatch string, failMatch string) (err error) {
+	if _, err = ParseSuccessCodes(successCodes); err != nil {
+		return
+	}
+	if _, err = regexp.Compile(successMatch); err != nil {
+		return fmt.Errorf("成功条件正则错误: %s", err)
+	}
+	if _, err = regexp.Compile(failMatch); err != nil {
+		return fmt.Errorf("失败条件正则错误: %s", err)
+	}
+	return
+}
+
+// UnpackArtifacts 反序列化日志中的任务产物列表
+func UnpackArtifacts(value string) (artifacts []*JobArtifact, err error) {
+	artifacts = make([]*JobArtifact, 0)
+	if value == "" {
+		return
+	}
+	err = json.Unmarshal([]byte(value), &artifacts)
+	return
+}
+
+// GetNumField 更新任务状态时，对执行过的任务的执行次数加 1
+func GetNumField(adm bool) int {
+	if adm {
+		return 1
+	}
+	return 0
+}
+
+// 不能作为密钥名的环境变量，注入后会改变任务的执行环境
+var reservedEnvNames = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "SHELL": true, "PWD": true, "IFS": true,
+	"ENV": true, "BASH_ENV": true, "TMPDIR": true, "LANG": true,
+}
+
+// 不能作为密钥名的环境变量前缀，CRONTAB_ 为任务模板变量
+var reservedEnvPrefixes = []string{"CRONTAB_", "LD_", "DYLD_"}
+
+// IsReservedEnvName 密钥名是否为保留的环境变量名
+func IsReservedEnvName(name string) bool {
+	name = strings.ToUpper(name)
+	if reservedEnvNames[name] {
+		return true
+	}
+	for _, prefix := range reservedEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
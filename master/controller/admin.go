@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/response"
+	"crontab/master/service"
+)
+
+// AdminLogPurge 按保留策略在后台清理一次执行日志，立即返回，结果通过 GET /admin/logs/purge 查询 POST /admin/logs/purge
+func AdminLogPurge(ctx *gin.Context) {
+	if !service.GLogPurger.Start() {
+		response.Fail(ctx, common.ErrPurgeRunning.Error(), gin.H{"report": service.GLogPurger.LastReport()})
+		return
+	}
+
+	response.Success(ctx, gin.H{"started": true}, nil)
+	return
+}
+
+// AdminLogPurgeReport 最近一次日志清理的结果 GET /admin/logs/purge
+func AdminLogPurgeReport(ctx *gin.Context) {
+	response.Success(ctx, gin.H{"report": service.GLogPurger.LastReport(), "running": service.GLogPurger.Running()}, nil)
+	return
+}
+
+// AdminLogLevel 查询 master 和 worker 的日志级别 GET /admin/log/level
+func AdminLogLevel(ctx *gin.Context) {
+	var (
+		err         error
+		workerLevel string
+	)
+
+	if workerLevel, err = service.GJobSer.GetWorkerLogLevel(); err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询 worker 日志级别失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"master": logger.GetLevel(), "worker": workerLevel}, nil)
+	return
+}
+
+// AdminLogLevelSet 运行时修改日志级别 POST /admin/log/level
+// level 为 debug/info/warn/error；target 为 master、worker 或 all(默认)；修改 worker 时 level 为空表示恢复为配置文件中的级别
+func AdminLogLevelSet(ctx *gin.Context) {
+	var (
+		err    error
+		level  string
+		target string
+	)
+
+	level = ctx.PostForm("level")
+	target = ctx.DefaultPostForm("target", "all")
+	if target != "all" && target != "master" && target != "worker" {
+		response.Fail(ctx, fmt.Sprintf("未知的修改对象： %s", target), nil)
+		return
+	}
+	if level != "" || target != "worker" {
+		if _, err = logger.ParseLevel(level); err != nil {
+			response.Fail(ctx, err.Error(), nil)
+			return
+		}
+	}
+
+	if target != "master" {
+		if err = service.GJobSer.SetWorkerLogLevel(strings.ToLower(level)); err != nil {
+			response.Fail(ctx, fmt.Sprintf("修改 worker 日志级别失败： %s", err), nil)
+			return
+		}
+	}
+	if target != "worker" {
+		logger.SetLevel(level)
+	}
+	logger.Info.With("target", target).Printf("日志级别修改为 %s ", level)
+
+	response.Success(ctx, gin.H{"master": logger.GetLevel()}, nil)
+	return
+}
+
+// AdminReconcile 立即检查一次 mysql 与 etcd 中的任务是否一致 POST /admin/reconcile
+func AdminReconcile(ctx *gin.Context) {
+	var (
+		err    error
+		report *service.ReconcileReport
+	)
+
+	if report, err = service.GReconciler.Reconcile(); err != nil {
+		response.Fail(ctx, fmt.Sprintf("任务一致性检查失败： %s", err), gin.H{"report": report})
+		return
+	}
+
+	response.Success(ctx, gin.H{"report": report}, nil)
+	return
+}
+
+// AdminReconcileReport 最近一次任务一致性检查的结果 GET /admin/reconcile
+func AdminReconcileReport(ctx *gin.Context) {
+	response.Success(ctx, gin.H{"report": service.GReconciler.LastReport()}, nil)
+	return
+}
@@ -1,10 +1,18 @@
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"crontab/master/common"
 	"crontab/master/logger"
@@ -59,84 +67,257 @@ func JobList(ctx *gin.Context) {
 // JobAdd 保存任务接口 POST job={"name": "job1", "command": "echo hello", "cronExpr": "* * * * *"}
 func JobAdd(ctx *gin.Context) {
 	var (
-		err      error
-		sqlRes   *gorm.DB
-		postData *common.Job
-		job      *model.Job
+		err           error
+		postData      *common.Job
+		job           *model.Job
+		newJob        *model.Job
+		scriptVersion int
+		secrets       []string
+		artifacts     []string
+		mutationID    string
+		selector      common.Selector
+		warning       string
 	)
 
 	name := ctx.PostForm("name")
 	command := ctx.PostForm("command")
 	cronExpr := ctx.PostForm("cronExpr")
 	jobType, _ := strconv.Atoi(ctx.PostForm("typ"))
+	outputLimit, _ := strconv.Atoi(ctx.PostForm("outputLimit"))
+	timeout, _ := strconv.Atoi(ctx.PostForm("timeout"))
+	kind, _ := strconv.Atoi(ctx.PostForm("kind"))
+	interpreter := ctx.PostForm("interpreter")
+	script := ctx.PostForm("script")
+	task := ctx.PostForm("task")
+	params := ctx.PostForm("params")
+	successCodes := ctx.PostForm("successCodes")
+	successMatch := ctx.PostForm("successMatch")
+	failMatch := ctx.PostForm("failMatch")
+	workDir := ctx.PostForm("workDir")
+	lockLostPolicy, _ := strconv.Atoi(ctx.PostForm("lockLostPolicy"))
+	retentionDays, _ := strconv.Atoi(ctx.PostForm("retentionDays"))
+	retentionCount, _ := strconv.Atoi(ctx.PostForm("retentionCount"))
 	user, _ := ctx.Get("user")
 
+	// 任务只保存密钥名，执行时由 worker 解密注入
+	if secrets, err = parseSecretNames(ctx.PostForm("secrets")); err != nil {
+		response.Fail(ctx, err.Error(), nil)
+		return
+	}
+
 	if common.GMsql.DB.Where("name = ?", name).First(&job).RowsAffected != 0 {
 		response.Fail(ctx, "任务已存在，请重新输入", nil)
 		return
 	}
 
-	// 保存到 mysql
-	if sqlRes = common.GMsql.DB.Create(&model.Job{
-		Name:     name,
-		Command:  command,
-		CronExpr: cronExpr,
-		Status:   0, // 待调度
-		Typ:      jobType,
-		Num:      0,
-		UserID:   int(user.(model.User).ID),
-	}); sqlRes.Error != nil {
-		logger.Error.Printf("新增任务插入 mysql 出错: ", sqlRes.Error)
-		response.Fail(ctx, fmt.Sprintf("任务保存失败： %s", err), nil)
+	// 校验命令模板
+	if kind == common.JobKind["命令任务"] {
+		if err = common.CheckCommand(command); err != nil {
+			response.Fail(ctx, fmt.Sprintf("命令模板错误： %s", err), nil)
+			return
+		}
+	}
+
+	// 脚本任务从版本 1 开始
+	if kind == common.JobKind["脚本任务"] {
+		if err = checkScript(interpreter, script); err != nil {
+			response.Fail(ctx, err.Error(), nil)
+			return
+		}
+		scriptVersion = 1
+	}
+
+	// 内置任务由 worker 注册，这里只校验参数格式
+	if kind == common.JobKind["内置任务"] {
+		if err = checkTask(task, params); err != nil {
+			response.Fail(ctx, err.Error(), nil)
+			return
+		}
+	}
+
+	// 成功条件：成功退出码、输出必须匹配和不能匹配的正则
+	if err = common.CheckSuccessRule(successCodes, successMatch, failMatch); err != nil {
+		response.Fail(ctx, err.Error(), nil)
 		return
 	}
 
-	// 保存到etcd
-	if _, err = service.GJobSer.AddJob(&common.Job{
-		Name:     name,
-		Command:  command,
-		CronExpr: cronExpr,
-		Typ:      jobType,
-		Num:      0,
+	// 产物文件的 glob，相对于工作目录
+	if artifacts, err = parseArtifacts(ctx.PostForm("artifacts")); err != nil {
+		response.Fail(ctx, err.Error(), nil)
+		return
+	}
+	if len(artifacts) > 0 && workDir == "" {
+		response.Fail(ctx, common.ErrArtifactWorkDir.Error(), nil)
+		return
+	}
+
+	// 节点选择器，只有标签满足的 worker 才会调度该任务
+	if selector, err = common.ParseSelector(ctx.PostForm("selector")); err != nil {
+		response.Fail(ctx, err.Error(), nil)
+		return
+	}
+
+	newJob = &model.Job{
+		Name:           name,
+		Command:        command,
+		CronExpr:       cronExpr,
+		Status:         0, // 待调度
+		Typ:            jobType,
+		Num:            0,
+		UserID:         int(user.(model.User).ID),
+		OutputLimit:    outputLimit,
+		Timeout:        timeout,
+		Kind:           kind,
+		Interpreter:    interpreter,
+		ScriptVersion:  scriptVersion,
+		Task:           task,
+		Params:         params,
+		Secrets:        strings.Join(secrets, ","),
+		SuccessCodes:   successCodes,
+		SuccessMatch:   successMatch,
+		FailMatch:      failMatch,
+		WorkDir:        workDir,
+		Artifacts:      strings.Join(artifacts, ","),
+		Selector:       selector.String(),
+		LockLostPolicy: lockLostPolicy,
+		RetentionDays:  retentionDays,
+		RetentionCount: retentionCount,
+	}
+
+	// 写入 etcd 的任务
+	etcdJob := &common.Job{
+		Name:           name,
+		Command:        command,
+		CronExpr:       cronExpr,
+		Typ:            jobType,
+		Num:            0,
+		OutputLimit:    outputLimit,
+		Timeout:        timeout,
+		Kind:           kind,
+		Interpreter:    interpreter,
+		Script:         script,
+		ScriptVersion:  scriptVersion,
+		Task:           task,
+		Params:         params,
+		Secrets:        secrets,
+		SuccessCodes:   successCodes,
+		SuccessMatch:   successMatch,
+		FailMatch:      failMatch,
+		WorkDir:        workDir,
+		Artifacts:      artifacts,
+		Selector:       selector.String(),
+		LockLostPolicy: lockLostPolicy,
+	}
+
+	// 保存到 mysql，脚本任务同时保存第一个版本的脚本，同一个事务中写入发布到 etcd 的变更
+	if err = common.GMsql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Create(newJob).Error; err != nil {
+			return
+		}
+		if scriptVersion != 0 {
+			if err = tx.Create(&model.JobScript{
+				JobID:       int(newJob.ID),
+				JobName:     name,
+				Version:     scriptVersion,
+				Interpreter: interpreter,
+				Script:      script,
+				UserID:      newJob.UserID,
+			}).Error; err != nil {
+				return
+			}
+		}
+		mutationID, err = service.GOutbox.Enqueue(tx, common.MutationOp["保存"], name, etcdJob)
+		return
 	}); err != nil {
-		logger.Error.Printf("新增任务插入 etcd 出错: ", err)
+		logger.Error.Printf("新增任务插入 mysql 出错: %s ", err)
 		response.Fail(ctx, fmt.Sprintf("任务保存失败： %s", err), nil)
-		// TODO 将 mysql 中的此任务标记删除
 		return
 	}
+	service.GOutbox.Notify()
+
+	// 没有在线 worker 满足选择器时任务不会被执行，保存成功但给出提示
+	warning = checkPlacement(name, selector)
 
-	response.Success(ctx, gin.H{"job": postData}, nil)
+	response.Success(ctx, gin.H{"job": postData, "mutationId": mutationID, "warning": warning}, nil)
 	return
 
 }
 
-// JobDelete 删除任务接口 POST /job/delete   name=job1
-func JobDelete(ctx *gin.Context) {
+// 检查是否有在线 worker 满足任务的选择器，没有时返回提示
+func checkPlacement(name string, selector common.Selector) (warning string) {
 	var (
-		err    error // interface{}
-		oldJob *common.Job
+		err     error
+		matched []*common.WorkerInfo
 	)
-	name := ctx.PostForm("name")
 
-	// 删除 etcd 中任务
-	if oldJob, err = service.GJobSer.DeleteJob(name); err != nil {
-		// TODO error log
-		response.Fail(ctx, fmt.Sprintf("etcd 任务删除失败： %s", err), nil)
+	if len(selector) == 0 {
+		return
+	}
+	if matched, err = service.GWorkerSer.MatchWorkers(selector); err != nil {
+		logger.Warn.With("job", name).Printf("查询 worker 节点失败: %s ", err)
 		return
 	}
+	if len(matched) == 0 {
+		warning = fmt.Sprintf("没有在线 worker 满足节点选择器 %s，任务暂时不会被执行", selector)
+		logger.Warn.With("job", name).Println(warning)
+	}
+	return
+}
+
+// 校验内置任务的任务名和参数
+func checkTask(task string, params string) error {
+	if task == "" {
+		return common.ErrTaskEmpty
+	}
+	if params != "" && !json.Valid([]byte(params)) {
+		return common.ErrTaskParams
+	}
+	return nil
+}
+
+// 解析产物文件的 glob，逗号分隔，必须是相对路径
+func parseArtifacts(value string) (patterns []string, err error) {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if filepath.IsAbs(pattern) || strings.HasPrefix(filepath.Clean(pattern), "..") {
+			err = fmt.Errorf("产物路径必须相对于工作目录: %s", pattern)
+			return
+		}
+		if _, err = filepath.Match(pattern, ""); err != nil {
+			err = fmt.Errorf("产物路径格式错误: %s", pattern)
+			return
+		}
+		patterns = append(patterns, pattern)
+	}
+	return
+}
+
+// JobDelete 删除任务接口 POST /job/delete   name=job1
+func JobDelete(ctx *gin.Context) {
+	var (
+		err        error // interface{}
+		mutationID string
+	)
+	name := ctx.PostForm("name")
 
-	// etcd 中的任务已被删除
-	if oldJob == nil {
-		// 删除 mysql 中任务
-		if err = common.GMsql.DB.Model(model.Job{}).Where("name = ?", name).Updates(map[string]interface{}{
+	// 标记 mysql 中任务已删除，同一个事务中写入删除 etcd 任务的变更
+	if err = common.GMsql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Model(model.Job{}).Where("name = ?", name).Updates(map[string]interface{}{
 			"status": common.StatusTyp["已删除"],
 		}).Error; err != nil {
-			response.Fail(ctx, fmt.Sprintf("mysql 任务删除失败： %s", err), nil)
 			return
 		}
+		mutationID, err = service.GOutbox.Enqueue(tx, common.MutationOp["删除"], name, nil)
+		return
+	}); err != nil {
+		response.Fail(ctx, fmt.Sprintf("mysql 任务删除失败： %s", err), nil)
+		return
 	}
+	service.GOutbox.Notify()
 
-	response.Success(ctx, gin.H{"jobName": oldJob}, nil)
+	response.Success(ctx, gin.H{"jobName": name, "mutationId": mutationID}, nil)
 	return
 
 }
@@ -144,22 +325,36 @@ func JobDelete(ctx *gin.Context) {
 // JobKill 强制杀死某个任务 POST /job/kill  name=job1
 func JobKill(ctx *gin.Context) {
 	var (
-		err error
+		err        error
+		mutationID string
 	)
 
 	name := ctx.PostForm("name")
 
 	// 杀死任务
-	if err = service.GJobSer.KillJob(name); err != nil {
-		// TODO error log
+	if mutationID, err = service.GOutbox.Enqueue(common.GMsql.DB, common.MutationOp["强杀"], name, nil); err != nil {
 		response.Fail(ctx, fmt.Sprintf("任务强杀失败： %s", err), nil)
 		return
 	}
+	service.GOutbox.Notify()
+
+	response.Success(ctx, gin.H{"job": name, "mutationId": mutationID}, nil)
+	return
+}
+
+// JobMutation 查询任务变更的发布状态 GET /job/mutation?id=
+func JobMutation(ctx *gin.Context) {
+	var (
+		err      error
+		mutation *model.JobMutation
+	)
 
-	// TODO insert mysql
+	if mutation, err = service.GOutbox.GetMutation(ctx.Query("id")); err != nil {
+		response.Fail(ctx, fmt.Sprintf("任务变更查询失败： %s", err), nil)
+		return
+	}
 
-	// TODO error log
-	response.Success(ctx, gin.H{"job": name}, nil)
+	response.Success(ctx, gin.H{"mutation": mutation}, nil)
 	return
 }
 
@@ -170,23 +365,255 @@ func JobLogs(ctx *gin.Context) {
 		currentPage int
 		totalCount  int64
 		err         error
-		jobName     string // 任务名字
-		logs        []model.Log
+		filter      *common.LogFilter
+		logs        []*model.Log
 	)
 
-	jobName = ctx.PostForm("jobName")
 	pageSize, _ = strconv.Atoi(ctx.DefaultPostForm("pageSize", "8"))
 	currentPage, _ = strconv.Atoi(ctx.DefaultPostForm("currentPage", "1"))
+	if filter, err = parseLogFilter(ctx); err != nil {
+		response.Fail(ctx, err.Error(), nil)
+		return
+	}
 
-	logDB := common.GMsql.DB.Model(&model.Log{}).Where("job_name = ?", jobName)
-	if logDB.Error != nil {
-		logger.Error.Printf("查询日志失败: ", err)
+	// 从主日志存储查询
+	if logs, totalCount, err = service.GLogReader.Find(filter, (currentPage-1)*pageSize, pageSize); err != nil {
+		logger.Error.Printf("查询日志失败: %s ", err)
 		response.Fail(ctx, fmt.Sprintf("查询日志失败： %s", err), nil)
 		return
 	}
-	logDB.Count(&totalCount)
-	logDB.Order("id desc").Order("result").Offset((currentPage - 1) * pageSize).Limit(pageSize).Find(&logs)
 
 	response.Success(ctx, gin.H{"totalCount": totalCount, "logs": logs}, nil)
 	return
 }
+
+// 解析执行日志的查询条件，日志列表和日志导出使用相同的参数
+func parseLogFilter(ctx *gin.Context) (filter *common.LogFilter, err error) {
+	filter = &common.LogFilter{
+		JobName: ctx.PostForm("jobName"), // 任务名字
+		Signal:  ctx.PostForm("signal"),
+		Stdout:  ctx.PostForm("stdout"),
+		Stderr:  ctx.PostForm("stderr"),
+	}
+
+	// 按退出码、终止信号、标准输出和标准错误输出过滤
+	if exitCode, err := strconv.Atoi(ctx.PostForm("exitCode")); err == nil {
+		filter.ExitCode = &exitCode
+	}
+	if lockLost, err := strconv.ParseBool(ctx.PostForm("lockLost")); err == nil {
+		filter.LockLost = &lockLost
+	}
+
+	// 按执行结果、计划/实际开始时间范围、worker、执行耗时和输出内容过滤
+	filter.Result = ctx.PostForm("result")
+	filter.Worker = ctx.PostForm("worker")
+	filter.Text = ctx.PostForm("text")
+	for param, value := range map[string]**time.Time{
+		"planTimeStart":  &filter.PlanTimeStart,
+		"planTimeEnd":    &filter.PlanTimeEnd,
+		"startTimeStart": &filter.StartTimeStart,
+		"startTimeEnd":   &filter.StartTimeEnd,
+	} {
+		if ctx.PostForm(param) == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(common.LogTimeLayout, ctx.PostForm(param), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("时间格式错误(%s)： %s", param, err)
+		}
+		*value = &t
+	}
+	if minDuration, err := strconv.ParseInt(ctx.PostForm("minDuration"), 10, 64); err == nil {
+		filter.MinDurationMs = &minDuration
+	}
+	if maxDuration, err := strconv.ParseInt(ctx.PostForm("maxDuration"), 10, 64); err == nil {
+		filter.MaxDurationMs = &maxDuration
+	}
+	return
+}
+
+// JobLogOutput 下载任务执行的完整输出 GET /job/log/output?execution_id=xxx
+func JobLogOutput(ctx *gin.Context) {
+	var (
+		err    error
+		jobLog *model.Log
+		reader io.ReadCloser
+	)
+
+	// 从主日志存储查询，mongo 和 file 中的日志没有 mysql id
+	if jobLog, err = service.GLogReader.Get(ctx.Query("execution_id")); err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询日志失败： %s", err), nil)
+		return
+	}
+
+	disposition := fmt.Sprintf(`attachment; filename="%s-%s.log"`, jobLog.JobName, jobLog.ExecutionID)
+
+	// 输出没有被截断，日志中就是完整输出
+	if jobLog.OutputFile == "" {
+		ctx.Header("Content-Disposition", disposition)
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(jobLog.Output))
+		return
+	}
+
+	if reader, err = common.GStorage.Get(jobLog.OutputFile); err != nil {
+		logger.Error.Printf("读取完整输出失败: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("读取完整输出失败： %s", err), nil)
+		return
+	}
+	defer reader.Close()
+
+	ctx.DataFromReader(http.StatusOK, jobLog.OutputSize, "text/plain; charset=utf-8", reader, map[string]string{"Content-Disposition": disposition})
+}
+
+// JobLogDetail 任务执行日志详情，包括任务产物列表 GET /job/log?execution_id=xxx
+func JobLogDetail(ctx *gin.Context) {
+	var (
+		err       error
+		jobLog    *model.Log
+		artifacts []*common.JobArtifact
+	)
+
+	// 从主日志存储查询，mongo 和 file 中的日志没有 mysql id
+	if jobLog, err = service.GLogReader.Get(ctx.Query("execution_id")); err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询日志失败： %s", err), nil)
+		return
+	}
+
+	if artifacts, err = common.UnpackArtifacts(jobLog.Artifacts); err != nil {
+		response.Fail(ctx, fmt.Sprintf("解析任务产物失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"log": jobLog, "artifacts": artifacts}, nil)
+	return
+}
+
+// JobLogArtifact 下载任务产物 GET /job/log/artifact?execution_id=xxx&name=report/2021.csv
+func JobLogArtifact(ctx *gin.Context) {
+	var (
+		err       error
+		jobLog    *model.Log
+		artifacts []*common.JobArtifact
+		artifact  *common.JobArtifact
+		reader    io.ReadCloser
+	)
+
+	// 从主日志存储查询，mongo 和 file 中的日志没有 mysql id
+	if jobLog, err = service.GLogReader.Get(ctx.Query("execution_id")); err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询日志失败： %s", err), nil)
+		return
+	}
+	if artifacts, err = common.UnpackArtifacts(jobLog.Artifacts); err != nil {
+		response.Fail(ctx, fmt.Sprintf("解析任务产物失败： %s", err), nil)
+		return
+	}
+
+	// 只能下载日志中记录的、上传成功的产物
+	name := ctx.Query("name")
+	for _, item := range artifacts {
+		if item.Name == name && item.Err == "" {
+			artifact = item
+			break
+		}
+	}
+	if artifact == nil {
+		response.Fail(ctx, "任务产物不存在", nil)
+		return
+	}
+
+	if reader, err = common.GArtifactStorage.Get(artifact.Key); err != nil {
+		logger.Error.Printf("读取任务产物失败: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("读取任务产物失败： %s", err), nil)
+		return
+	}
+	defer reader.Close()
+
+	disposition := fmt.Sprintf(`attachment; filename="%s"`, path.Base(artifact.Name))
+	ctx.DataFromReader(http.StatusOK, artifact.Size, "application/octet-stream", reader, map[string]string{"Content-Disposition": disposition})
+}
+
+// JobTail 实时查看执行中任务的输出(SSE) GET /job/tail?name=job1
+func JobTail(ctx *gin.Context) {
+	var (
+		err        error
+		stateValue string
+		firstState common.JobOutputState
+		curState   common.JobOutputState
+		offset     int64 // 下一个要读取的片段序号
+		start      int64
+		firstValue string
+		chunks     []string
+		chunk      common.JobOutputChunk
+	)
+
+	name := ctx.Query("name")
+	listKey := common.JobOutputDir + name
+	stateKey := common.JobOutputStateDir + name
+
+	// 任务没有执行过或者输出已过期
+	if stateValue, err = common.GRdb.RDB.Get(ctx, stateKey).Result(); err != nil {
+		if err == redis.Nil {
+			response.Fail(ctx, "任务未在执行中或输出已过期", nil)
+			return
+		}
+		response.Fail(ctx, fmt.Sprintf("查询任务输出失败： %s", err), nil)
+		return
+	}
+	if err = json.Unmarshal([]byte(stateValue), &firstState); err != nil {
+		response.Fail(ctx, fmt.Sprintf("解析任务输出状态失败： %s", err), nil)
+		return
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		// 先读取状态再读取输出，保证任务结束前的输出都能被读到
+		if stateValue, err = common.GRdb.RDB.Get(ctx, stateKey).Result(); err != nil {
+			ctx.SSEvent("end", "任务输出已过期")
+			return false
+		}
+		if err = json.Unmarshal([]byte(stateValue), &curState); err != nil {
+			ctx.SSEvent("error", fmt.Sprintf("解析任务输出状态失败： %s", err))
+			return false
+		}
+		// 已经开始了新一轮的执行，本轮输出已被清空
+		if curState.StartTime != firstState.StartTime {
+			ctx.SSEvent("end", "任务已开始新一轮执行")
+			return false
+		}
+
+		// worker 只保留最近的片段，按第一个片段的序号计算续读位置
+		start = 0
+		if firstValue, err = common.GRdb.RDB.LIndex(ctx, listKey, 0).Result(); err == nil &&
+			json.Unmarshal([]byte(firstValue), &chunk) == nil && offset > chunk.Seq {
+			start = offset - chunk.Seq
+		}
+		if chunks, err = common.GRdb.RDB.LRange(ctx, listKey, start, -1).Result(); err != nil {
+			ctx.SSEvent("error", fmt.Sprintf("查询任务输出失败： %s", err))
+			return false
+		}
+		for _, value := range chunks {
+			if err = json.Unmarshal([]byte(value), &chunk); err != nil || chunk.Seq < offset {
+				continue
+			}
+			if chunk.Seq > offset {
+				ctx.SSEvent("skipped", fmt.Sprintf("输出过多，省略了 %d 个片段", chunk.Seq-offset))
+			}
+			ctx.SSEvent(chunk.Stream, chunk.Data)
+			offset = chunk.Seq + 1
+		}
+
+		if curState.Status == common.JobOutputDone {
+			ctx.SSEvent("end", "任务执行结束")
+			return false
+		}
+
+		select {
+		case <-ctx.Request.Context().Done(): // 客户端断开
+			return false
+		case <-ticker.C:
+		}
+		return true
+	})
+}
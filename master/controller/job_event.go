@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"crontab/master/common"
+	"crontab/master/model"
+	"crontab/master/response"
+)
+
+// ExecutionTimeline 一次执行的时间线
+type ExecutionTimeline struct {
+	ExecutionID string                  `json:"execution_id"`
+	Events      []*model.ExecutionEvent `json:"events"`
+}
+
+// JobExecutionEvents 查询一次执行的状态变化 GET /job/execution/events?executionId=xxx
+func JobExecutionEvents(ctx *gin.Context) {
+	var (
+		err         error
+		executionID string
+		events      []*model.ExecutionEvent
+	)
+
+	if executionID = ctx.Query("executionId"); executionID == "" {
+		response.Fail(ctx, "执行 id 不能为空", nil)
+		return
+	}
+
+	if err = common.GMsql.DB.Where("execution_id = ?", executionID).Order("event_time").Order("id").Find(&events).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询执行事件失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"timeline": &ExecutionTimeline{ExecutionID: executionID, Events: events}}, nil)
+	return
+}
+
+// JobEvents 按执行分组查询任务最近的执行时间线，执行按最近一次事件倒序 GET /job/events?name=xxx&currentPage=1&pageSize=10
+func JobEvents(ctx *gin.Context) {
+	var (
+		err          error
+		name         string
+		pageSize     int
+		currentPage  int
+		totalCount   int64
+		executionIDs []string
+		events       []*model.ExecutionEvent
+		timelines    []*ExecutionTimeline
+		timelineMap  map[string]*ExecutionTimeline
+	)
+
+	if name = ctx.Query("name"); name == "" {
+		response.Fail(ctx, "任务名不能为空", nil)
+		return
+	}
+	pageSize, _ = strconv.Atoi(ctx.DefaultQuery("pageSize", "10"))
+	currentPage, _ = strconv.Atoi(ctx.DefaultQuery("currentPage", "1"))
+
+	eventDB := common.GMsql.DB.Model(&model.ExecutionEvent{}).Where("job_name = ?", name)
+	if err = eventDB.Distinct("execution_id").Count(&totalCount).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询执行事件失败： %s", err), nil)
+		return
+	}
+
+	// 当前页的执行，再查询这些执行的全部事件
+	if err = common.GMsql.DB.Model(&model.ExecutionEvent{}).Where("job_name = ?", name).
+		Group("execution_id").Order("MAX(id) desc").Offset((currentPage-1)*pageSize).Limit(pageSize).
+		Pluck("execution_id", &executionIDs).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询执行事件失败： %s", err), nil)
+		return
+	}
+	if len(executionIDs) > 0 {
+		if err = common.GMsql.DB.Where("execution_id IN ?", executionIDs).Order("event_time").Order("id").Find(&events).Error; err != nil {
+			response.Fail(ctx, fmt.Sprintf("查询执行事件失败： %s", err), nil)
+			return
+		}
+	}
+
+	timelines = make([]*ExecutionTimeline, 0, len(executionIDs))
+	timelineMap = make(map[string]*ExecutionTimeline)
+	for _, executionID := range executionIDs {
+		timelineMap[executionID] = &ExecutionTimeline{ExecutionID: executionID, Events: []*model.ExecutionEvent{}}
+		timelines = append(timelines, timelineMap[executionID])
+	}
+	for _, event := range events {
+		timelineMap[event.ExecutionID].Events = append(timelineMap[event.ExecutionID].Events, event)
+	}
+
+	response.Success(ctx, gin.H{"totalCount": totalCount, "timelines": timelines}, nil)
+	return
+}
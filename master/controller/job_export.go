@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+	"crontab/master/response"
+	"crontab/master/service"
+)
+
+// 导出时每批从日志存储读取的条数，同时也是刷新响应的间隔
+const exportBatchSize = 500
+
+// CSV 导出的表头，和 exportRow 中的字段一一对应
+var exportHeader = []string{
+	"id", "execution_id", "job_name", "worker", "worker_id", "command", "result", "exit_code", "signal",
+	"plan_time", "schedule_time", "start_time", "end_time", "duration_ms", "user_cpu_ms", "sys_cpu_ms", "max_rss_kb",
+	"lock_lost", "lock_lost_time", "output_size", "output_truncated", "output_file", "artifacts", "err", "output",
+}
+
+// JobLogExport 导出执行日志 POST /job/logs/export，查询参数与 /job/logs 相同，format 为 csv(默认) 或 jsonl
+// 响应使用分块传输，边查询边输出；开始输出后出现的错误通过 X-Export-Error trailer 返回，导出的条数通过 X-Export-Rows trailer 返回
+func JobLogExport(ctx *gin.Context) {
+	var (
+		err       error
+		filter    *common.LogFilter
+		format    string
+		rows      int64
+		csvWriter *csv.Writer
+		line      []byte
+	)
+
+	format = ctx.DefaultPostForm("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		response.Fail(ctx, fmt.Sprintf("不支持的导出格式： %s", format), nil)
+		return
+	}
+	if filter, err = parseLogFilter(ctx); err != nil {
+		response.Fail(ctx, err.Error(), nil)
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="crontab-logs-%s.%s"`, time.Now().Format("20060102150405"), format))
+	ctx.Header("Trailer", "X-Export-Rows, X-Export-Error")
+	if format == "csv" {
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		csvWriter = csv.NewWriter(ctx.Writer)
+		csvWriter.Write(exportHeader)
+	} else {
+		ctx.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	}
+
+	err = service.GLogReader.Each(filter, exportBatchSize, func(log *model.Log) (err error) {
+		if csvWriter != nil {
+			err = csvWriter.Write(exportRow(log))
+		} else if line, err = json.Marshal(log); err == nil {
+			line = append(line, '\n')
+			_, err = ctx.Writer.Write(line)
+		}
+		if err != nil {
+			return
+		}
+
+		// 每批刷新一次，把已经查询到的日志发送给客户端
+		if rows++; rows%exportBatchSize == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			ctx.Writer.Flush()
+		}
+		return
+	})
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err == nil {
+			err = csvWriter.Error()
+		}
+	}
+
+	ctx.Writer.Header().Set("X-Export-Rows", strconv.FormatInt(rows, 10))
+	if err != nil {
+		logger.Error.Printf("导出日志失败: %s ", err)
+		ctx.Writer.Header().Set("X-Export-Error", strings.ReplaceAll(err.Error(), "\n", " "))
+	}
+	ctx.Writer.Flush()
+}
+
+// 一条日志转换为 CSV 的一行
+func exportRow(log *model.Log) []string {
+	var (
+		lockLostTime string
+	)
+
+	if log.LockLostTime != nil {
+		lockLostTime = log.LockLostTime.Format(common.ExportTimeLayout)
+	}
+	return []string{
+		strconv.FormatUint(uint64(log.ID), 10),
+		log.ExecutionID,
+		log.JobName,
+		log.Worker,
+		log.WorkerID,
+		log.Command,
+		log.Result,
+		strconv.Itoa(log.ExitCode),
+		log.Signal,
+		log.PlanTime.Format(common.ExportTimeLayout),
+		log.ScheduleTime.Format(common.ExportTimeLayout),
+		log.StartTime.Format(common.ExportTimeLayout),
+		log.EndTime.Format(common.ExportTimeLayout),
+		strconv.FormatInt(log.DurationMs, 10),
+		strconv.FormatInt(log.UserCPUMs, 10),
+		strconv.FormatInt(log.SysCPUMs, 10),
+		strconv.FormatInt(log.MaxRSSKB, 10),
+		strconv.FormatBool(log.LockLost),
+		lockLostTime,
+		strconv.FormatInt(log.OutputSize, 10),
+		strconv.FormatBool(log.OutputTruncated),
+		log.OutputFile,
+		log.Artifacts,
+		log.Err,
+		log.Output,
+	}
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+	"crontab/master/response"
+	"crontab/master/service"
+)
+
+// 校验脚本任务的解释器和脚本内容
+func checkScript(interpreter string, script string) error {
+	if _, ok := common.Interpreters[interpreter]; !ok {
+		return common.ErrInterpreterUnknown
+	}
+	if script == "" {
+		return common.ErrScriptEmpty
+	}
+	return nil
+}
+
+// JobScriptSave 修改脚本任务的脚本，保存为新版本 POST /job/script/save name=job1 interpreter=python3 script=...
+func JobScriptSave(ctx *gin.Context) {
+	var (
+		err        error
+		job        model.Job
+		version    int
+		etcdJob    *common.Job
+		mutationID string
+	)
+
+	name := ctx.PostForm("name")
+	interpreter := ctx.PostForm("interpreter")
+	script := ctx.PostForm("script")
+	user, _ := ctx.Get("user")
+
+	if err = common.GMsql.DB.Where("name = ?", name).First(&job).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("任务查询失败： %s", err), nil)
+		return
+	}
+	if job.Kind != common.JobKind["脚本任务"] {
+		response.Fail(ctx, "该任务不是脚本任务", nil)
+		return
+	}
+	if err = checkScript(interpreter, script); err != nil {
+		response.Fail(ctx, err.Error(), nil)
+		return
+	}
+
+	// 保存新版本，并更新任务当前使用的版本；任务可以调度时同一个事务中写入同步到 etcd 的变更，
+	// 变更根据事务中加锁读取的任务构造，不会覆盖尚未发布的删除或修改
+	if err = common.GMsql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", name).First(&job).Error; err != nil {
+			return
+		}
+		version = job.ScriptVersion + 1
+		if err = tx.Create(&model.JobScript{
+			JobID:       int(job.ID),
+			JobName:     name,
+			Version:     version,
+			Interpreter: interpreter,
+			Script:      script,
+			UserID:      int(user.(model.User).ID),
+		}).Error; err != nil {
+			return
+		}
+		if err = tx.Model(&job).Updates(map[string]interface{}{
+			"interpreter":    interpreter,
+			"script_version": version,
+		}).Error; err != nil {
+			return
+		}
+		// 已删除或单次任务已执行结束的任务只保存新版本
+		if !service.JobSchedulable(job) {
+			return
+		}
+		job.Interpreter = interpreter
+		job.ScriptVersion = version
+		if etcdJob, err = service.BuildJob(tx, &job); err != nil {
+			return
+		}
+		mutationID, err = service.GOutbox.Enqueue(tx, common.MutationOp["保存"], name, etcdJob)
+		return
+	}); err != nil {
+		logger.Error.Printf("保存脚本新版本出错: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("脚本保存失败： %s", err), nil)
+		return
+	}
+	service.GOutbox.Notify()
+
+	response.Success(ctx, gin.H{"jobName": name, "version": version, "mutationId": mutationID}, nil)
+	return
+}
+
+// JobScriptVersions 查询脚本任务的所有版本 GET /job/script/versions?name=job1
+func JobScriptVersions(ctx *gin.Context) {
+	var (
+		err      error
+		versions []model.JobScript
+	)
+
+	name := ctx.Query("name")
+
+	// 列表中不返回脚本内容
+	if err = common.GMsql.DB.Model(&model.JobScript{}).
+		Select("id", "created_at", "job_id", "job_name", "version", "interpreter", "user_id").
+		Where("job_name = ?", name).Order("version desc").Find(&versions).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("脚本版本查询失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"versions": versions}, nil)
+	return
+}
+
+// JobScriptDetail 查询脚本任务某个版本的脚本，不指定版本时返回当前使用的版本 GET /job/script?name=job1&version=2
+func JobScriptDetail(ctx *gin.Context) {
+	var (
+		err     error
+		job     model.Job
+		version int
+		script  model.JobScript
+	)
+
+	name := ctx.Query("name")
+
+	if err = common.GMsql.DB.Where("name = ?", name).First(&job).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("任务查询失败： %s", err), nil)
+		return
+	}
+	if version, err = strconv.Atoi(ctx.Query("version")); err != nil {
+		version = job.ScriptVersion
+	}
+
+	if err = common.GMsql.DB.Where("job_id = ? AND version = ?", job.ID, version).First(&script).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("脚本查询失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"script": script, "current": version == job.ScriptVersion}, nil)
+	return
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"crontab/master/common"
+	"crontab/master/model"
+	"crontab/master/response"
+	"crontab/master/service"
+)
+
+// 计算分位数时每个任务最多读取的日志条数，按开始时间取最近的日志
+const jobStatsSampleLimit = 50000
+
+// jobStatsRow 计算分位数用到的日志字段
+type jobStatsRow struct {
+	JobName    string
+	DurationMs int64
+	MaxRSSKB   int64 `gorm:"column:max_rss_kb"`
+}
+
+// jobStatsAgg mysql 中聚合的统计数据
+type jobStatsAgg struct {
+	JobName       string
+	Count         int
+	MaxDurationMs int64
+	AvgCPUMs      float64
+	MaxMaxRSSKB   int64
+}
+
+// JobStats 任务资源使用统计
+type JobStats struct {
+	JobName       string  `json:"job_name"`
+	Count         int     `json:"count"`           // 执行次数
+	Samples       int     `json:"samples"`         // 计算分位数使用的执行次数，超过上限时只使用该任务最近的执行
+	P50DurationMs int64   `json:"p50_duration_ms"` // 执行耗时中位数(毫秒)
+	P95DurationMs int64   `json:"p95_duration_ms"` // 执行耗时 95 分位(毫秒)
+	MaxDurationMs int64   `json:"max_duration_ms"` // 最长执行耗时(毫秒)
+	AvgCPUMs      float64 `json:"avg_cpu_ms"`      // 平均 CPU 时间(用户态 + 内核态，毫秒)
+	P50MaxRSSKB   int64   `json:"p50_max_rss_kb"`  // 最大常驻内存中位数(KB)
+	P95MaxRSSKB   int64   `json:"p95_max_rss_kb"`  // 最大常驻内存 95 分位(KB)
+	MaxMaxRSSKB   int64   `json:"max_max_rss_kb"`  // 最大常驻内存最大值(KB)
+}
+
+// JobStatsList 按任务统计执行耗时、CPU 和内存 GET /job/stats?name=job1&start=2021/01/01 00:00:00&end=2021/01/02 00:00:00
+// 不传 name 时统计所有任务；时间范围按任务开始执行时间过滤，不传 start 时默认统计最近 7 天
+func JobStatsList(ctx *gin.Context) {
+	var (
+		err       error
+		startTime time.Time
+		endTime   time.Time
+		stats     []*JobStats
+	)
+
+	endTime = time.Now()
+	if end := ctx.Query("end"); end != "" {
+		if endTime, err = time.ParseInLocation(common.LogTimeLayout, end, time.Local); err != nil {
+			response.Fail(ctx, fmt.Sprintf("结束时间格式错误： %s", err), nil)
+			return
+		}
+	}
+	startTime = endTime.AddDate(0, 0, -7)
+	if start := ctx.Query("start"); start != "" {
+		if startTime, err = time.ParseInLocation(common.LogTimeLayout, start, time.Local); err != nil {
+			response.Fail(ctx, fmt.Sprintf("开始时间格式错误： %s", err), nil)
+			return
+		}
+	}
+
+	// 主日志存储是 mongo 或 file 时日志不在 mysql 中，通过 GLogReader 遍历统计
+	if primary := common.GConfig.LogSink.Primary; primary == "" || primary == "mysql" {
+		stats, err = mysqlJobStats(ctx.Query("name"), startTime, endTime)
+	} else {
+		stats, err = readerJobStats(ctx.Query("name"), startTime, endTime)
+	}
+	if err != nil {
+		response.Fail(ctx, fmt.Sprintf("查询日志失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{
+		"start": startTime.Format(common.LogTimeLayout),
+		"end":   endTime.Format(common.LogTimeLayout),
+		"stats": stats,
+	}, nil)
+	return
+}
+
+// 在 mysql 中聚合次数、最大值和平均值，分位数逐个任务读取最近的日志在内存中计算
+func mysqlJobStats(name string, startTime time.Time, endTime time.Time) (stats []*JobStats, err error) {
+	var (
+		aggs []jobStatsAgg
+		rows []jobStatsRow
+	)
+
+	logDB := common.GMsql.DB.Model(&model.Log{}).
+		Where("start_time >= ? AND start_time < ?", startTime, endTime)
+	if name != "" {
+		logDB = logDB.Where("job_name = ?", name)
+	}
+
+	if err = logDB.Session(&gorm.Session{}).
+		Select("job_name, COUNT(*) AS count, MAX(duration_ms) AS max_duration_ms, " +
+			"AVG(user_cpu_ms + sys_cpu_ms) AS avg_cpu_ms, MAX(max_rss_kb) AS max_max_rss_kb").
+		Group("job_name").Order("job_name").Scan(&aggs).Error; err != nil {
+		return
+	}
+
+	// mysql 没有分位数函数，每个任务单独取样，执行频繁的任务不会占满其它任务的样本
+	stats = make([]*JobStats, 0, len(aggs))
+	for _, agg := range aggs {
+		rows = nil
+		if err = logDB.Session(&gorm.Session{}).Where("job_name = ?", agg.JobName).
+			Select("job_name, duration_ms, max_rss_kb").
+			Order("start_time desc").Limit(jobStatsSampleLimit).Scan(&rows).Error; err != nil {
+			return
+		}
+		stats = append(stats, buildJobStats(agg, rows))
+	}
+	return
+}
+
+// 通过 GLogReader 逐条遍历日志统计，日志按执行时间倒序，每个任务只保留最近的日志计算分位数
+func readerJobStats(name string, startTime time.Time, endTime time.Time) (stats []*JobStats, err error) {
+	var (
+		aggMap  map[string]*jobStatsAgg
+		cpuSum  map[string]int64
+		jobRows map[string][]jobStatsRow
+		aggs    []jobStatsAgg
+	)
+
+	aggMap = make(map[string]*jobStatsAgg)
+	cpuSum = make(map[string]int64)
+	jobRows = make(map[string][]jobStatsRow)
+	filter := &common.LogFilter{JobName: name, StartTimeStart: &startTime, StartTimeEnd: &endTime}
+	if err = service.GLogReader.Each(filter, 500, func(log *model.Log) error {
+		agg, ok := aggMap[log.JobName]
+		if !ok {
+			agg = &jobStatsAgg{JobName: log.JobName}
+			aggMap[log.JobName] = agg
+		}
+		agg.Count++
+		if log.DurationMs > agg.MaxDurationMs {
+			agg.MaxDurationMs = log.DurationMs
+		}
+		if log.MaxRSSKB > agg.MaxMaxRSSKB {
+			agg.MaxMaxRSSKB = log.MaxRSSKB
+		}
+		cpuSum[log.JobName] += log.UserCPUMs + log.SysCPUMs
+		if len(jobRows[log.JobName]) < jobStatsSampleLimit {
+			jobRows[log.JobName] = append(jobRows[log.JobName], jobStatsRow{JobName: log.JobName, DurationMs: log.DurationMs, MaxRSSKB: log.MaxRSSKB})
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+
+	for jobName, agg := range aggMap {
+		agg.AvgCPUMs = float64(cpuSum[jobName]) / float64(agg.Count)
+		aggs = append(aggs, *agg)
+	}
+	sort.Slice(aggs, func(i, j int) bool { return aggs[i].JobName < aggs[j].JobName })
+
+	stats = make([]*JobStats, 0, len(aggs))
+	for _, agg := range aggs {
+		stats = append(stats, buildJobStats(agg, jobRows[agg.JobName]))
+	}
+	return
+}
+
+// 计算一个任务的统计数据，rows 为计算分位数的日志
+func buildJobStats(agg jobStatsAgg, rows []jobStatsRow) (stats *JobStats) {
+	var (
+		durations []int64
+		rssArr    []int64
+	)
+
+	for _, row := range rows {
+		durations = append(durations, row.DurationMs)
+		rssArr = append(rssArr, row.MaxRSSKB)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	sort.Slice(rssArr, func(i, j int) bool { return rssArr[i] < rssArr[j] })
+
+	return &JobStats{
+		JobName:       agg.JobName,
+		Count:         agg.Count,
+		Samples:       len(rows),
+		P50DurationMs: percentile(durations, 50),
+		P95DurationMs: percentile(durations, 95),
+		MaxDurationMs: agg.MaxDurationMs,
+		AvgCPUMs:      agg.AvgCPUMs,
+		P50MaxRSSKB:   percentile(rssArr, 50),
+		P95MaxRSSKB:   percentile(rssArr, 95),
+		MaxMaxRSSKB:   agg.MaxMaxRSSKB,
+	}
+}
+
+// percentile 最近秩法计算分位数，values 必须已经升序排列
+func percentile(values []int64, p int) int64 {
+	var (
+		rank int
+	)
+
+	if len(values) == 0 {
+		return 0
+	}
+	rank = (p*len(values) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/response"
+	"crontab/master/service"
+)
+
+// Report worker 上报任务状态、执行事件和执行日志 POST /report
+// 请求体为记录的 JSON 数组，请求头 X-Report-Token 需要与配置中的 report.token 一致
+func Report(ctx *gin.Context) {
+	var (
+		err     error
+		token   string
+		records []*common.SpoolRecord
+	)
+
+	token = common.GConfig.Report.Token
+	if token == "" || subtle.ConstantTimeCompare([]byte(ctx.GetHeader("X-Report-Token")), []byte(token)) != 1 {
+		response.Fail(ctx, "上报 token 错误", nil)
+		return
+	}
+
+	if err = ctx.ShouldBindJSON(&records); err != nil {
+		response.Fail(ctx, fmt.Sprintf("解析上报记录失败： %s", err), nil)
+		return
+	}
+	if err = service.GReportSer.ApplyReport(records); err != nil {
+		logger.Error.Printf("上报记录写入数据库失败: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("上报记录写入数据库失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"count": len(records)}, nil)
+	return
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+	"crontab/master/response"
+	"crontab/master/service"
+)
+
+// 密钥名会作为环境变量名注入任务
+var secretNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretList 列举所有密钥，不返回密钥值 GET /secret/list
+func SecretList(ctx *gin.Context) {
+	var (
+		err     error
+		secrets []model.Secret
+	)
+
+	if err = common.GMsql.DB.Order("name").Find(&secrets).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("密钥查询失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"secrets": secrets}, nil)
+	return
+}
+
+// SecretSave 新增或修改密钥 POST /secret/save name=DB_PASSWORD value=xxx description=xxx
+func SecretSave(ctx *gin.Context) {
+	var (
+		err       error
+		encrypted string
+		secret    model.Secret
+	)
+
+	name := ctx.PostForm("name")
+	value := ctx.PostForm("value")
+	description := ctx.PostForm("description")
+	user, _ := ctx.Get("user")
+
+	if !secretNameRegexp.MatchString(name) {
+		response.Fail(ctx, common.ErrSecretName.Error(), nil)
+		return
+	}
+	if common.IsReservedEnvName(name) {
+		response.Fail(ctx, common.ErrSecretReserved.Error(), nil)
+		return
+	}
+
+	// 加密后再保存，mysql 和 etcd 中都只有密文
+	if encrypted, err = common.EncryptSecret(value); err != nil {
+		logger.Error.Printf("密钥加密失败: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("密钥加密失败： %s", err), nil)
+		return
+	}
+
+	common.GMsql.DB.Where("name = ?", name).First(&secret)
+	secret.Name = name
+	secret.Value = encrypted
+	secret.Description = description
+	secret.UserID = int(user.(model.User).ID)
+	if err = common.GMsql.DB.Save(&secret).Error; err != nil {
+		logger.Error.Printf("密钥保存 mysql 失败: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("密钥保存失败： %s", err), nil)
+		return
+	}
+
+	if err = service.GSecretSer.SaveSecret(name, encrypted); err != nil {
+		logger.Error.Printf("密钥保存 etcd 失败: %s ", err)
+		response.Fail(ctx, fmt.Sprintf("密钥保存失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"secret": secret}, nil)
+	return
+}
+
+// SecretDelete 删除密钥 POST /secret/delete name=DB_PASSWORD
+func SecretDelete(ctx *gin.Context) {
+	var (
+		err error
+	)
+
+	name := ctx.PostForm("name")
+
+	if err = service.GSecretSer.DeleteSecret(name); err != nil {
+		response.Fail(ctx, fmt.Sprintf("etcd 密钥删除失败： %s", err), nil)
+		return
+	}
+	if err = common.GMsql.DB.Unscoped().Where("name = ?", name).Delete(&model.Secret{}).Error; err != nil {
+		response.Fail(ctx, fmt.Sprintf("mysql 密钥删除失败： %s", err), nil)
+		return
+	}
+
+	response.Success(ctx, gin.H{"name": name}, nil)
+	return
+}
+
+// 解析并校验任务引用的密钥，密钥必须已经存在
+func parseSecretNames(value string) (names []string, err error) {
+	var (
+		count int64
+	)
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if common.IsReservedEnvName(name) {
+			err = common.ErrSecretReserved
+			return
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	if err = common.GMsql.DB.Model(&model.Secret{}).Where("name IN ?", names).Count(&count).Error; err != nil {
+		return
+	}
+	if int(count) != len(names) {
+		err = fmt.Errorf("引用的密钥不存在: %s", strings.Join(names, ","))
+	}
+	return
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"crontab/master/common"
 	"crontab/master/response"
 	"crontab/master/service"
 )
@@ -17,12 +18,12 @@ func WorkerList(ctx *gin.Context) {
 		pageSize    int
 		currentPage int
 		totalCount  int
+		workerArr   []*common.WorkerInfo
 	)
 
 	pageSize, _ = strconv.Atoi(ctx.DefaultQuery("pageSize", "8"))
 	currentPage, _ = strconv.Atoi(ctx.DefaultQuery("currentPage", "1"))
 
-	workerArr := make([]map[string]string, 10)
 	if workerArr, err = service.GWorkerSer.ListWorkers(); err != nil {
 		response.Fail(ctx, fmt.Sprintf("查询 worker 节点失败: %s", err), nil)
 		return
@@ -3,11 +3,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/gin-gonic/gin"
 
+	"crontab/master/cli"
 	"crontab/master/common"
+	"crontab/master/logger"
 	"crontab/master/router"
 	"crontab/master/service"
 )
@@ -32,6 +35,15 @@ func initEnv() {
 
 func main() {
 
+	// 子命令: master export ... 调用 master 接口导出执行日志
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err = cli.Export(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 初始化命令行参数
 	initArgs()
 
@@ -43,6 +55,11 @@ func main() {
 		goto ERR
 	}
 
+	// 服务日志
+	if err = logger.Init(common.GConfig.Log); err != nil {
+		goto ERR
+	}
+
 	// redis 连接池
 	if err = common.InitRedisConn(); err != nil {
 		goto ERR
@@ -53,21 +70,56 @@ func main() {
 		goto ERR
 	}
 
+	// 文件存储
+	if err = common.InitStorage(); err != nil {
+		goto ERR
+	}
+
 	//// mongodb 连接池
 	//if err = common.InitMongoConn(); err != nil {
 	//	goto ERR
 	//}
 
+	// 执行日志查询，主日志存储为 mongo 时会建立 mongodb 连接
+	if err = service.InitLogReader(); err != nil {
+		goto ERR
+	}
+
 	//  JobService 任务管理器
 	if err = service.InitJobSer(); err != nil {
 		goto ERR
 	}
 
+	// Outbox 任务变更发布
+	if err = service.InitOutbox(); err != nil {
+		goto ERR
+	}
+
 	// WorkerService 集群节点管理器
 	if err = service.InitWorkerSer(); err != nil {
 		goto ERR
 	}
 
+	// SecretService 密钥管理器
+	if err = service.InitSecretSer(); err != nil {
+		goto ERR
+	}
+
+	// ReportService worker 上报记录处理
+	if err = service.InitReportSer(); err != nil {
+		goto ERR
+	}
+
+	// mysql 与 etcd 任务一致性检查
+	if err = service.InitReconciler(); err != nil {
+		goto ERR
+	}
+
+	// 执行日志清理
+	if err = service.InitLogPurger(); err != nil {
+		goto ERR
+	}
+
 	// 启动 HTTP 服务
 	eng = gin.Default()
 	eng = router.RegisterRoute(eng)
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// ExecutionEvent 一次执行的状态变化，按时间顺序组成执行的时间线
+type ExecutionEvent struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ExecutionID string    `gorm:"size:64;index" json:"execution_id"`           // 执行 id
+	JobName     string    `gorm:"size:64;index" json:"job_name"`               // 任务名字
+	Worker      string    `gorm:"size:64" json:"worker"`                       // 执行任务的 worker
+	WorkerID    string    `gorm:"size:64" json:"worker_id"`                    // 执行任务的 worker 标识
+	Status      int       `json:"status"`                                      // 变化后的任务状态
+	Reason      string    `gorm:"size:32" json:"reason"`                       // 状态变化的原因
+	Message     string    `gorm:"type:text" json:"message"`                    // 附加信息，如错误原因
+	EventTime   time.Time `gorm:"type:datetime(3);not null" json:"event_time"` // 状态变化的时间
+	CreatedAt   time.Time `json:"created_at"`
+}
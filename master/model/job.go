@@ -8,14 +8,31 @@ import (
 
 type Job struct {
 	gorm.Model
-	ID       uint       `gorm:"primaryKey" json:"id"`
-	Name     string     `gorm:"type:varchar(20);not null" json:"name"`      //  任务名
-	Command  string     `gorm:"type:varchar(255);not null" json:"command"`  // shell命令
-	CronExpr string     `gorm:"type:varchar(20);not null" json:"cron_expr"` // cron表达式
-	Status   int        `json:"status"`                                     // 执行状态
-	NextTime *time.Time `json:"next_time"`                                  // 下次调度时间
-	Typ      int        `json:"typ"`                                        // 任务类型(0: 定时任务；1: 单次任务)
-	Num      int        `json:"num"`                                        // 执行次数
-	UserID   int        `json:"user_id"`                                    // 默认外键，用户 id
-	Logs     []Log      // 一对多关联属性，表示多条日志
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Name           string     `gorm:"type:varchar(20);not null" json:"name"`      //  任务名
+	Command        string     `gorm:"type:varchar(255);not null" json:"command"`  // shell命令
+	CronExpr       string     `gorm:"type:varchar(20);not null" json:"cron_expr"` // cron表达式
+	Status         int        `json:"status"`                                     // 执行状态
+	NextTime       *time.Time `json:"next_time"`                                  // 下次调度时间
+	Typ            int        `json:"typ"`                                        // 任务类型(0: 定时任务；1: 单次任务)
+	Num            int        `json:"num"`                                        // 执行次数
+	OutputLimit    int        `json:"output_limit"`                               // 输出大小上限(字节)，0 表示使用全局配置
+	Timeout        int        `json:"timeout"`                                    // 执行超时时间(秒)，0 表示不限制
+	Kind           int        `json:"kind"`                                       // 执行方式(0: 命令任务；1: 脚本任务；2: 内置任务)
+	Interpreter    string     `gorm:"type:varchar(16)" json:"interpreter"`        // 脚本解释器
+	ScriptVersion  int        `json:"script_version"`                             // 当前使用的脚本版本
+	Task           string     `gorm:"type:varchar(64)" json:"task"`               // 内置任务名
+	Params         string     `gorm:"type:text" json:"params"`                    // 内置任务的 JSON 参数
+	Secrets        string     `gorm:"type:varchar(255)" json:"secrets"`           // 引用的密钥名，逗号分隔
+	SuccessCodes   string     `gorm:"type:varchar(64)" json:"success_codes"`      // 成功退出码，逗号分隔，为空时只有 0 表示成功
+	SuccessMatch   string     `gorm:"type:varchar(255)" json:"success_match"`     // 输出必须匹配的正则
+	FailMatch      string     `gorm:"type:varchar(255)" json:"fail_match"`        // 输出不能匹配的正则
+	WorkDir        string     `gorm:"type:varchar(255)" json:"work_dir"`          // 工作目录
+	Artifacts      string     `gorm:"type:varchar(255)" json:"artifacts"`         // 产物文件的 glob，逗号分隔
+	Selector       string     `gorm:"type:varchar(255)" json:"selector"`          // 节点选择器，为空时所有 worker 都可以执行
+	LockLostPolicy int        `json:"lock_lost_policy"`                           // 锁丢失后的处理策略(0: 标记；1: 强杀)
+	RetentionDays  int        `json:"retention_days"`                             // 日志保留天数，0 表示使用全局配置，-1 表示不限制
+	RetentionCount int        `json:"retention_count"`                            // 日志保留条数，0 表示使用全局配置，-1 表示不限制
+	UserID         int        `json:"user_id"`                                    // 默认外键，用户 id
+	Logs           []Log      // 一对多关联属性，表示多条日志
 }
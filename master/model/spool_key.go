@@ -0,0 +1,9 @@
+package model
+
+import "time"
+
+// SpoolKey 已经写入数据库的 worker 上报记录，用于重复上报时去重
+type SpoolKey struct {
+	Key       string    `gorm:"type:varchar(64);primaryKey" json:"key"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -20,9 +20,33 @@ func RegisterRoute(egn *gin.Engine) *gin.Engine {
 	egn.POST("/job/add", middleware.AuthMiddleware(), controller.JobAdd)
 	egn.POST("/job/delete", middleware.AuthMiddleware(), controller.JobDelete)
 	egn.POST("/job/kill", middleware.AuthMiddleware(), controller.JobKill)
+	egn.GET("/job/mutation", middleware.AuthMiddleware(), controller.JobMutation)
 	egn.POST("/job/logs", middleware.AuthMiddleware(), controller.JobLogs)
+	egn.POST("/job/logs/export", middleware.AuthMiddleware(), controller.JobLogExport)
+	egn.GET("/job/tail", middleware.AuthMiddleware(), controller.JobTail)
+	egn.GET("/job/stats", middleware.AuthMiddleware(), controller.JobStatsList)
+	egn.GET("/job/log", middleware.AuthMiddleware(), controller.JobLogDetail)
+	egn.GET("/job/log/output", middleware.AuthMiddleware(), controller.JobLogOutput)
+	egn.GET("/job/log/artifact", middleware.AuthMiddleware(), controller.JobLogArtifact)
+	egn.GET("/job/events", middleware.AuthMiddleware(), controller.JobEvents)
+	egn.GET("/job/execution/events", middleware.AuthMiddleware(), controller.JobExecutionEvents)
+	egn.POST("/job/script/save", middleware.AuthMiddleware(), controller.JobScriptSave)
+	egn.GET("/job/script/versions", middleware.AuthMiddleware(), controller.JobScriptVersions)
+	egn.GET("/job/script", middleware.AuthMiddleware(), controller.JobScriptDetail)
 
 	egn.GET("/worker/list", middleware.AuthMiddleware(), controller.WorkerList)
+	egn.POST("/report", controller.Report)
+
+	egn.GET("/secret/list", middleware.AuthMiddleware(), controller.SecretList)
+	egn.POST("/secret/save", middleware.AuthMiddleware(), controller.SecretSave)
+	egn.POST("/secret/delete", middleware.AuthMiddleware(), controller.SecretDelete)
+
+	egn.POST("/admin/logs/purge", middleware.AuthMiddleware(), controller.AdminLogPurge)
+	egn.GET("/admin/logs/purge", middleware.AuthMiddleware(), controller.AdminLogPurgeReport)
+	egn.GET("/admin/log/level", middleware.AuthMiddleware(), controller.AdminLogLevel)
+	egn.POST("/admin/reconcile", middleware.AuthMiddleware(), controller.AdminReconcile)
+	egn.GET("/admin/reconcile", middleware.AuthMiddleware(), controller.AdminReconcileReport)
+	egn.POST("/admin/log/level", middleware.AuthMiddleware(), controller.AdminLogLevelSet)
 
 	return egn
 
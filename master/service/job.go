@@ -55,6 +55,26 @@ func (_self *JobSer) AddJob(job *common.Job) (oldJob *common.Job, err error) {
 	return
 }
 
+// GetJob 查询任务，任务不存在时返回 nil
+func (_self *JobSer) GetJob(name string) (job *common.Job, err error) {
+	var (
+		getResp *clientv3.GetResponse
+	)
+
+	if getResp, err = _self.kv.Get(context.TODO(), common.JobSaveDir+name); err != nil {
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		return
+	}
+
+	job = &common.Job{}
+	if err = json.Unmarshal(getResp.Kvs[0].Value, job); err != nil {
+		job = nil
+	}
+	return
+}
+
 // DeleteJob 删除任务
 func (_self *JobSer) DeleteJob(name string) (oldJob *common.Job, err error) {
 	var (
@@ -85,6 +105,12 @@ func (_self *JobSer) DeleteJob(name string) (oldJob *common.Job, err error) {
 
 // ListJobs 列举任务
 func (_self *JobSer) ListJobs() (jobList []*common.Job, err error) {
+	jobList, _, _, err = _self.ListJobRevisions()
+	return
+}
+
+// ListJobRevisions 列举任务，同时返回每个任务最后修改时的 revision 和读取时 etcd 的 revision
+func (_self *JobSer) ListJobRevisions() (jobList []*common.Job, modRevisions map[string]int64, revision int64, err error) {
 	var (
 		dirKey  string
 		getResp *clientv3.GetResponse
@@ -103,6 +129,8 @@ func (_self *JobSer) ListJobs() (jobList []*common.Job, err error) {
 	// 初始化数组空间
 	jobList = make([]*common.Job, 0)
 	// len(jobList) == 0
+	modRevisions = make(map[string]int64)
+	revision = getResp.Header.Revision
 
 	// 遍历所有任务, 进行反序列化
 	for _, kvPair = range getResp.Kvs {
@@ -112,6 +140,7 @@ func (_self *JobSer) ListJobs() (jobList []*common.Job, err error) {
 			continue
 		}
 		jobList = append(jobList, job)
+		modRevisions[job.Name] = kvPair.ModRevision
 	}
 	return
 }
@@ -143,6 +172,44 @@ func (_self *JobSer) KillJob(name string) (err error) {
 	return
 }
 
+// IsJobLocked 任务是否持有执行锁，即是否有 worker 正在执行
+func (_self *JobSer) IsJobLocked(name string) (locked bool, err error) {
+	var (
+		getResp *clientv3.GetResponse
+	)
+
+	if getResp, err = _self.kv.Get(context.TODO(), common.JobLockDir+name, clientv3.WithCountOnly()); err != nil {
+		return
+	}
+	locked = getResp.Count > 0
+	return
+}
+
+// SetWorkerLogLevel 修改所有 worker 的日志级别，level 为空时恢复为 worker 配置文件中的级别
+func (_self *JobSer) SetWorkerLogLevel(level string) (err error) {
+	if level == "" {
+		_, err = _self.kv.Delete(context.TODO(), common.WorkerLogLevelKey)
+		return
+	}
+	_, err = _self.kv.Put(context.TODO(), common.WorkerLogLevelKey, level)
+	return
+}
+
+// GetWorkerLogLevel 通过 etcd 设置的 worker 日志级别，没有设置时为空
+func (_self *JobSer) GetWorkerLogLevel() (level string, err error) {
+	var (
+		getResp *clientv3.GetResponse
+	)
+
+	if getResp, err = _self.kv.Get(context.TODO(), common.WorkerLogLevelKey); err != nil {
+		return
+	}
+	if len(getResp.Kvs) > 0 {
+		level = string(getResp.Kvs[0].Value)
+	}
+	return
+}
+
 // InitJobSer 初始化管理器
 func InitJobSer() (err error) {
 	var (
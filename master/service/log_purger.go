@@ -0,0 +1,329 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+)
+
+var (
+	GLogPurger *LogPurger
+)
+
+// PurgeReport 一次日志清理的结果
+type PurgeReport struct {
+	StartTime time.Time        `json:"start_time"`
+	EndTime   time.Time        `json:"end_time"`
+	Deleted   int64            `json:"deleted"`       // 删除的日志条数
+	Events    int64            `json:"events"`        // 删除的执行事件条数
+	Archived  int64            `json:"archived"`      // 删除前归档的日志条数
+	Jobs      map[string]int64 `json:"jobs"`          // 每个任务删除的日志条数
+	Err       string           `json:"err,omitempty"` // 清理中断的原因
+}
+
+// LogPurger 按保留策略清理 mysql 中过期的执行日志，分批删除，每批之间暂停，避免长时间锁表
+type LogPurger struct {
+	running    int32      // 是否正在清理，同一时间只执行一次清理
+	reportLock sync.Mutex // 只保护 lastReport，查询结果时不用等待正在执行的清理
+	lastReport *PurgeReport
+}
+
+// LastReport 最近一次清理的结果
+func (_self *LogPurger) LastReport() *PurgeReport {
+	_self.reportLock.Lock()
+	defer _self.reportLock.Unlock()
+	return _self.lastReport
+}
+
+// Running 是否正在清理
+func (_self *LogPurger) Running() bool {
+	return atomic.LoadInt32(&_self.running) == 1
+}
+
+// Start 在后台执行一次清理，已经在清理时返回 false
+func (_self *LogPurger) Start() (started bool) {
+	if !atomic.CompareAndSwapInt32(&_self.running, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&_self.running, 0)
+		if _, err := _self.purge(); err != nil {
+			logger.Error.Printf("日志清理失败: %s ", err)
+		}
+	}()
+	return true
+}
+
+// Purge 执行一次清理，已经在清理时返回 ErrPurgeRunning
+func (_self *LogPurger) Purge() (report *PurgeReport, err error) {
+	if !atomic.CompareAndSwapInt32(&_self.running, 0, 1) {
+		err = common.ErrPurgeRunning
+		return
+	}
+	defer atomic.StoreInt32(&_self.running, 0)
+	return _self.purge()
+}
+
+// 执行一次清理，调用方需要设置 running
+func (_self *LogPurger) purge() (report *PurgeReport, err error) {
+	var (
+		jobNames []string
+		jobs     []model.Job
+		jobMap   map[string]model.Job
+		days     int
+		count    int
+		ids      []uint
+	)
+
+	report = &PurgeReport{StartTime: time.Now(), Jobs: make(map[string]int64)}
+	defer func() {
+		report.EndTime = time.Now()
+		if err != nil {
+			report.Err = err.Error()
+		}
+		_self.reportLock.Lock()
+		_self.lastReport = report
+		_self.reportLock.Unlock()
+		logger.Info.Printf("日志清理完成，删除日志 %d 条、执行事件 %d 条，归档 %d 条，耗时 %s ", report.Deleted, report.Events, report.Archived, report.EndTime.Sub(report.StartTime))
+	}()
+
+	if common.GConfig.Retention.MaxAgeDays > 0 || common.GConfig.Retention.MaxCount > 0 {
+		// 有日志的任务，已经删除的任务的日志按全局策略清理
+		if err = common.GMsql.DB.Unscoped().Model(&model.Log{}).Distinct("job_name").Pluck("job_name", &jobNames).Error; err != nil {
+			return
+		}
+	} else {
+		// 没有全局策略时只清理单独设置了保留策略的任务
+		if err = common.GMsql.DB.Model(&model.Job{}).Where("retention_days > 0 OR retention_count > 0").
+			Pluck("name", &jobNames).Error; err != nil {
+			return
+		}
+	}
+	if err = common.GMsql.DB.Where("name IN ?", jobNames).Order("id").Find(&jobs).Error; err != nil {
+		return
+	}
+	jobMap = make(map[string]model.Job)
+	for _, job := range jobs {
+		jobMap[job.Name] = job
+	}
+
+	for _, jobName := range jobNames {
+		days, count = retentionOf(jobMap[jobName])
+
+		// 按保留天数清理
+		if days > 0 {
+			if err = _self.purgeBatches(report, jobName, "created_at < ?", time.Now().AddDate(0, 0, -days)); err != nil {
+				return
+			}
+			// 没有对应日志的执行事件(如跳过执行、抢锁失败)按时间清理
+			if err = _self.purgeEventBatches(report, jobName, time.Now().AddDate(0, 0, -days)); err != nil {
+				return
+			}
+		}
+
+		// 按保留条数清理，找到第 count+1 新的日志，删除它以及更早的日志
+		if count > 0 {
+			ids = nil
+			if err = common.GMsql.DB.Unscoped().Model(&model.Log{}).Where("job_name = ?", jobName).
+				Order("id desc").Offset(count).Limit(1).Pluck("id", &ids).Error; err != nil {
+				return
+			}
+			if len(ids) > 0 {
+				if err = _self.purgeBatches(report, jobName, "id <= ?", ids[0]); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// 任务的保留天数和条数，任务没有单独设置时使用全局配置，小于 0 表示不限制
+func retentionOf(job model.Job) (days int, count int) {
+	if days = job.RetentionDays; days == 0 {
+		days = common.GConfig.Retention.MaxAgeDays
+	}
+	if count = job.RetentionCount; count == 0 {
+		count = common.GConfig.Retention.MaxCount
+	}
+	return
+}
+
+// 分批删除任务满足条件的日志
+func (_self *LogPurger) purgeBatches(report *PurgeReport, jobName string, query string, args ...interface{}) (err error) {
+	var (
+		conf         common.RetentionConf
+		batch        []*model.Log
+		ids          []uint
+		executionIDs []string
+	)
+
+	conf = common.GConfig.Retention
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 500
+	}
+
+	for {
+		batch = nil
+		logDB := common.GMsql.DB.Unscoped().Where("job_name = ?", jobName).Where(query, args...)
+		// 不归档时只需要 id 和关联的文件
+		if !conf.Archive {
+			logDB = logDB.Select("id", "execution_id", "output_file", "artifacts")
+		}
+		if err = logDB.Order("id").Limit(conf.BatchSize).Find(&batch).Error; err != nil {
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		if conf.Archive {
+			if err = archiveLogs(jobName, batch); err != nil {
+				return
+			}
+			report.Archived += int64(len(batch))
+		} else {
+			deleteLogFiles(batch)
+		}
+
+		ids = ids[:0]
+		executionIDs = executionIDs[:0]
+		for _, log := range batch {
+			ids = append(ids, log.ID)
+			executionIDs = append(executionIDs, log.ExecutionID)
+		}
+		// 执行事件和日志一起删除
+		result := common.GMsql.DB.Where("execution_id IN ?", executionIDs).Delete(&model.ExecutionEvent{})
+		if err = result.Error; err != nil {
+			return
+		}
+		report.Events += result.RowsAffected
+		if err = common.GMsql.DB.Unscoped().Delete(&model.Log{}, ids).Error; err != nil {
+			return
+		}
+		report.Deleted += int64(len(batch))
+		report.Jobs[jobName] += int64(len(batch))
+
+		if len(batch) < conf.BatchSize {
+			return
+		}
+		time.Sleep(time.Duration(conf.BatchSleep) * time.Millisecond)
+	}
+}
+
+// 按 id 分批删除任务在 before 之前的执行事件
+func (_self *LogPurger) purgeEventBatches(report *PurgeReport, jobName string, before time.Time) (err error) {
+	var (
+		conf common.RetentionConf
+		ids  []uint
+	)
+
+	conf = common.GConfig.Retention
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 500
+	}
+
+	for {
+		ids = nil
+		if err = common.GMsql.DB.Model(&model.ExecutionEvent{}).Where("job_name = ? AND event_time < ?", jobName, before).
+			Order("id").Limit(conf.BatchSize).Pluck("id", &ids).Error; err != nil {
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+		if err = common.GMsql.DB.Delete(&model.ExecutionEvent{}, ids).Error; err != nil {
+			return
+		}
+		report.Events += int64(len(ids))
+
+		if len(ids) < conf.BatchSize {
+			return
+		}
+		time.Sleep(time.Duration(conf.BatchSleep) * time.Millisecond)
+	}
+}
+
+// 把一批日志以 JSONL 保存到文件存储，日志关联的完整输出和产物文件保留
+func archiveLogs(jobName string, logs []*model.Log) (err error) {
+	var (
+		buf  bytes.Buffer
+		line []byte
+	)
+
+	for _, log := range logs {
+		if line, err = json.Marshal(log); err != nil {
+			return
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return common.GStorage.Put(
+		fmt.Sprintf("archive/logs/%s/%s.jsonl", jobName, time.Now().Format("20060102150405.000000000")),
+		&buf, int64(buf.Len()),
+	)
+}
+
+// 删除日志关联的完整输出和产物文件，删除失败不影响日志清理
+func deleteLogFiles(logs []*model.Log) {
+	var (
+		err       error
+		artifacts []*common.JobArtifact
+	)
+
+	for _, log := range logs {
+		if log.OutputFile != "" {
+			if err = common.GStorage.Delete(log.OutputFile); err != nil {
+				logger.Warn.Printf("删除完整输出文件失败: %s ", err)
+			}
+		}
+		if artifacts, err = common.UnpackArtifacts(log.Artifacts); err != nil {
+			continue
+		}
+		for _, artifact := range artifacts {
+			if artifact.Err != "" {
+				continue
+			}
+			if err = common.GArtifactStorage.Delete(artifact.Key); err != nil {
+				logger.Warn.Printf("删除任务产物失败: %s ", err)
+			}
+		}
+	}
+}
+
+// 定时清理协程
+func (_self *LogPurger) purgeLoop() {
+	var (
+		err      error
+		interval int
+	)
+
+	// 没有配置间隔时按默认间隔执行，只会清理单独设置了保留策略的任务
+	if interval = common.GConfig.Retention.Interval; interval == 0 {
+		interval = 60
+	}
+	for {
+		time.Sleep(time.Duration(interval) * time.Minute)
+		// 手动触发的清理还没有结束时跳过本次
+		if _, err = _self.Purge(); err != nil && err != common.ErrPurgeRunning {
+			logger.Error.Printf("日志清理失败: %s ", err)
+		}
+	}
+}
+
+// InitLogPurger 初始化日志清理器，清理间隔小于 0 时不启动定时清理
+func InitLogPurger() (err error) {
+	GLogPurger = &LogPurger{}
+
+	if common.GConfig.Retention.Interval >= 0 {
+		go GLogPurger.purgeLoop()
+	}
+	return
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"gorm.io/gorm"
+
+	"crontab/master/common"
+	"crontab/master/model"
+)
+
+var (
+	GLogReader LogReader
+)
+
+// LogReader 从主日志存储查询执行日志，结果按执行时间倒序
+type LogReader interface {
+	Find(filter *common.LogFilter, skip int, limit int) (logs []*model.Log, total int64, err error)
+	// Each 按执行时间倒序逐条遍历满足条件的日志，分批读取，不会一次加载全部结果；fn 返回错误时停止遍历
+	Each(filter *common.LogFilter, batchSize int, fn func(log *model.Log) error) error
+	// Get 按执行 id 查询一条日志，不存在时返回 ErrLogNotFound
+	Get(executionID string) (*model.Log, error)
+}
+
+// mysqlLogReader 查询 mysql log 表
+type mysqlLogReader struct {
+}
+
+func (_self *mysqlLogReader) Find(filter *common.LogFilter, skip int, limit int) (logs []*model.Log, total int64, err error) {
+	logDB := mysqlLogQuery(filter)
+	if err = logDB.Count(&total).Error; err != nil {
+		return
+	}
+	err = logDB.Order("id desc").Order("result").Offset(skip).Limit(limit).Find(&logs).Error
+	return
+}
+
+// Each 按 id 游标分页，每批从上一批最小的 id 继续查询，避免大偏移量的 OFFSET
+func (_self *mysqlLogReader) Each(filter *common.LogFilter, batchSize int, fn func(log *model.Log) error) (err error) {
+	var (
+		batch  []*model.Log
+		lastID uint
+	)
+
+	for {
+		batch = nil
+		logDB := mysqlLogQuery(filter)
+		if lastID > 0 {
+			logDB = logDB.Where("id < ?", lastID)
+		}
+		if err = logDB.Order("id desc").Limit(batchSize).Find(&batch).Error; err != nil {
+			return
+		}
+		for _, log := range batch {
+			if err = fn(log); err != nil {
+				return
+			}
+		}
+		if len(batch) < batchSize {
+			return
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+func (_self *mysqlLogReader) Get(executionID string) (*model.Log, error) {
+	return getLog(_self, executionID)
+}
+
+// 根据查询条件构造 mysql 查询
+func mysqlLogQuery(filter *common.LogFilter) *gorm.DB {
+	logDB := common.GMsql.DB.Model(&model.Log{})
+	if filter.JobName != "" {
+		logDB = logDB.Where("job_name = ?", filter.JobName)
+	}
+	if filter.ExecutionID != "" {
+		logDB = logDB.Where("execution_id = ?", filter.ExecutionID)
+	}
+
+	// 按退出码、终止信号、标准输出和标准错误输出过滤
+	if filter.ExitCode != nil {
+		logDB = logDB.Where("exit_code = ?", *filter.ExitCode)
+	}
+	if filter.Signal != "" {
+		logDB = logDB.Where("`signal` = ?", filter.Signal)
+	}
+	if filter.Stdout != "" {
+		logDB = logDB.Where("stdout LIKE ?", "%"+filter.Stdout+"%")
+	}
+	if filter.Stderr != "" {
+		logDB = logDB.Where("stderr LIKE ?", "%"+filter.Stderr+"%")
+	}
+	if filter.LockLost != nil {
+		logDB = logDB.Where("lock_lost = ?", *filter.LockLost)
+	}
+
+	// 按执行结果、时间范围、worker 和执行耗时过滤
+	if filter.Result != "" {
+		logDB = logDB.Where("result = ?", filter.Result)
+	}
+	if filter.PlanTimeStart != nil {
+		logDB = logDB.Where("plan_time >= ?", *filter.PlanTimeStart)
+	}
+	if filter.PlanTimeEnd != nil {
+		logDB = logDB.Where("plan_time < ?", *filter.PlanTimeEnd)
+	}
+	if filter.StartTimeStart != nil {
+		logDB = logDB.Where("start_time >= ?", *filter.StartTimeStart)
+	}
+	if filter.StartTimeEnd != nil {
+		logDB = logDB.Where("start_time < ?", *filter.StartTimeEnd)
+	}
+	if filter.Worker != "" {
+		logDB = logDB.Where("worker = ? OR worker_id = ?", filter.Worker, filter.Worker)
+	}
+	if filter.MinDurationMs != nil {
+		logDB = logDB.Where("duration_ms >= ?", *filter.MinDurationMs)
+	}
+	if filter.MaxDurationMs != nil {
+		logDB = logDB.Where("duration_ms <= ?", *filter.MaxDurationMs)
+	}
+
+	// 输出和错误信息全文检索，默认使用 FULLTEXT 索引，按短语匹配
+	if filter.Text != "" {
+		if common.GConfig.LogSink.Search == "like" {
+			logDB = logDB.Where("output LIKE ? OR err LIKE ?", "%"+filter.Text+"%", "%"+filter.Text+"%")
+		} else {
+			logDB = logDB.Where("MATCH(output, err) AGAINST (? IN BOOLEAN MODE)", `"`+strings.ReplaceAll(filter.Text, `"`, " ")+`"`)
+		}
+	}
+	return logDB
+}
+
+// mongoLogReader 查询 mongodb 日志集合，字段名为 model.Log 字段名的小写
+type mongoLogReader struct {
+}
+
+func (_self *mongoLogReader) Find(filter *common.LogFilter, skip int, limit int) (logs []*model.Log, total int64, err error) {
+	var (
+		query bson.M
+		count int
+	)
+
+	query = mongoLogQuery(filter)
+	if count, err = common.GMgo.Count(common.LogCollection, query); err != nil {
+		return
+	}
+	total = int64(count)
+	err = common.GMgo.Find(common.LogCollection, query, limit, skip, &logs)
+	return
+}
+
+// Each 使用 mongodb 游标遍历
+func (_self *mongoLogReader) Each(filter *common.LogFilter, batchSize int, fn func(log *model.Log) error) error {
+	return common.GMgo.Iter(common.LogCollection, mongoLogQuery(filter), batchSize, func(iter *mgo.Iter) (err error) {
+		for {
+			log := &model.Log{}
+			if !iter.Next(log) {
+				return
+			}
+			if err = fn(log); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func (_self *mongoLogReader) Get(executionID string) (*model.Log, error) {
+	return getLog(_self, executionID)
+}
+
+// 根据查询条件构造 mongodb 查询
+func mongoLogQuery(filter *common.LogFilter) (query bson.M) {
+	query = bson.M{}
+	if filter.JobName != "" {
+		query["jobname"] = filter.JobName
+	}
+	if filter.ExecutionID != "" {
+		query["executionid"] = filter.ExecutionID
+	}
+	if filter.ExitCode != nil {
+		query["exitcode"] = *filter.ExitCode
+	}
+	if filter.Signal != "" {
+		query["signal"] = filter.Signal
+	}
+	if filter.Stdout != "" {
+		query["stdout"] = bson.RegEx{Pattern: regexp.QuoteMeta(filter.Stdout)}
+	}
+	if filter.Stderr != "" {
+		query["stderr"] = bson.RegEx{Pattern: regexp.QuoteMeta(filter.Stderr)}
+	}
+	if filter.LockLost != nil {
+		query["locklost"] = *filter.LockLost
+	}
+	if filter.Result != "" {
+		query["result"] = filter.Result
+	}
+	if timeRange := mongoRange(filter.PlanTimeStart, filter.PlanTimeEnd); timeRange != nil {
+		query["plantime"] = timeRange
+	}
+	if timeRange := mongoRange(filter.StartTimeStart, filter.StartTimeEnd); timeRange != nil {
+		query["starttime"] = timeRange
+	}
+	if filter.Worker != "" {
+		query["$and"] = []bson.M{{"$or": []bson.M{{"worker": filter.Worker}, {"workerid": filter.Worker}}}}
+	}
+	if filter.MinDurationMs != nil || filter.MaxDurationMs != nil {
+		durationRange := bson.M{}
+		if filter.MinDurationMs != nil {
+			durationRange["$gte"] = *filter.MinDurationMs
+		}
+		if filter.MaxDurationMs != nil {
+			durationRange["$lte"] = *filter.MaxDurationMs
+		}
+		query["durationms"] = durationRange
+	}
+	if filter.Text != "" {
+		query["$or"] = []bson.M{
+			{"output": bson.RegEx{Pattern: regexp.QuoteMeta(filter.Text)}},
+			{"err": bson.RegEx{Pattern: regexp.QuoteMeta(filter.Text)}},
+		}
+	}
+	return
+}
+
+// mongodb 时间范围查询条件，没有范围时返回 nil
+func mongoRange(start *time.Time, end *time.Time) bson.M {
+	var (
+		timeRange bson.M
+	)
+
+	if start == nil && end == nil {
+		return nil
+	}
+	timeRange = bson.M{}
+	if start != nil {
+		timeRange["$gte"] = *start
+	}
+	if end != nil {
+		timeRange["$lt"] = *end
+	}
+	return timeRange
+}
+
+// fileLogReader 读取 worker 写入的 JSONL 日志文件，master 需要挂载同一目录
+type fileLogReader struct {
+	dir string
+}
+
+// 找到足够的结果后停止遍历
+var errLogFindDone = errors.New("log find done")
+
+// Find 找到 skip+limit 条之后只再多找一条就停止，不会读取全部文件；
+// total 最多为 skip+limit+1，超过 skip+limit 时表示还有下一页
+func (_self *fileLogReader) Find(filter *common.LogFilter, skip int, limit int) (logs []*model.Log, total int64, err error) {
+	if err = _self.Each(filter, 0, func(log *model.Log) error {
+		if total >= int64(skip) && len(logs) < limit {
+			logs = append(logs, log)
+		}
+		if total++; total > int64(skip+limit) {
+			return errLogFindDone
+		}
+		return nil
+	}); err == errLogFindDone {
+		err = nil
+	}
+	return
+}
+
+// Each 逐个文件从末尾向前逐行读取，不会把文件加载到内存
+func (_self *fileLogReader) Each(filter *common.LogFilter, batchSize int, fn func(log *model.Log) error) (err error) {
+	var (
+		files   []string
+		history []string
+	)
+
+	// 当前文件之后按时间倒序读取历史文件
+	if history, err = filepath.Glob(filepath.Join(_self.dir, "crontab-log-*.jsonl")); err != nil {
+		return
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(history)))
+	files = append([]string{filepath.Join(_self.dir, "crontab-log.jsonl")}, history...)
+
+	for _, name := range files {
+		// 文件中的日志按写入顺序排列，倒序读取
+		if err = eachLineReverse(name, func(line []byte) error {
+			log := &model.Log{}
+			if json.Unmarshal(line, log) != nil || !matchLog(filter, log) {
+				return nil
+			}
+			return fn(log)
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Get 从最新的文件开始查找，找到后停止读取
+func (_self *fileLogReader) Get(executionID string) (*model.Log, error) {
+	return getLog(_self, executionID)
+}
+
+// 从文件末尾向前逐行读取，文件不存在时直接返回；正在写入的最后一行不完整时解析失败被跳过
+func eachLineReverse(name string, fn func(line []byte) error) (err error) {
+	var (
+		file  *os.File
+		info  os.FileInfo
+		pos   int64
+		n     int64
+		buf   []byte
+		chunk []byte
+		rest  []byte // 跨块的行的后半部分
+		i     int
+	)
+
+	if file, err = os.Open(name); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+
+	if info, err = file.Stat(); err != nil {
+		return
+	}
+	buf = make([]byte, 64*1024)
+	for pos = info.Size(); pos > 0; {
+		if n = int64(len(buf)); n > pos {
+			n = pos
+		}
+		pos -= n
+		if _, err = file.ReadAt(buf[:n], pos); err != nil {
+			return
+		}
+		chunk = append(append(make([]byte, 0, int(n)+len(rest)), buf[:n]...), rest...)
+		for {
+			if i = bytes.LastIndexByte(chunk, '\n'); i < 0 {
+				break
+			}
+			if line := chunk[i+1:]; len(line) > 0 {
+				if err = fn(line); err != nil {
+					return
+				}
+			}
+			chunk = chunk[:i]
+		}
+		rest = chunk
+	}
+	if len(rest) > 0 {
+		err = fn(rest)
+	}
+	return
+}
+
+// 判断日志是否满足查询条件
+func matchLog(filter *common.LogFilter, log *model.Log) bool {
+	return (filter.JobName == "" || log.JobName == filter.JobName) &&
+		(filter.ExecutionID == "" || log.ExecutionID == filter.ExecutionID) &&
+		(filter.ExitCode == nil || log.ExitCode == *filter.ExitCode) &&
+		(filter.Signal == "" || log.Signal == filter.Signal) &&
+		(filter.Stdout == "" || strings.Contains(log.Stdout, filter.Stdout)) &&
+		(filter.Stderr == "" || strings.Contains(log.Stderr, filter.Stderr)) &&
+		(filter.LockLost == nil || log.LockLost == *filter.LockLost) &&
+		(filter.Result == "" || log.Result == filter.Result) &&
+		inTimeRange(log.PlanTime, filter.PlanTimeStart, filter.PlanTimeEnd) &&
+		inTimeRange(log.StartTime, filter.StartTimeStart, filter.StartTimeEnd) &&
+		(filter.Worker == "" || log.Worker == filter.Worker || log.WorkerID == filter.Worker) &&
+		(filter.MinDurationMs == nil || log.DurationMs >= *filter.MinDurationMs) &&
+		(filter.MaxDurationMs == nil || log.DurationMs <= *filter.MaxDurationMs) &&
+		(filter.Text == "" || strings.Contains(log.Output, filter.Text) || strings.Contains(log.Err, filter.Text))
+}
+
+// 日志中的时间是否在范围内
+func inTimeRange(value time.Time, start *time.Time, end *time.Time) bool {
+	return (start == nil || !value.Before(*start)) && (end == nil || value.Before(*end))
+}
+
+// 按执行 id 遍历日志，取到第一条后停止，同一次执行有多条日志时返回最新的一条
+func getLog(reader LogReader, executionID string) (log *model.Log, err error) {
+	if executionID == "" {
+		return nil, common.ErrLogNotFound
+	}
+	if err = reader.Each(&common.LogFilter{ExecutionID: executionID}, 1, func(item *model.Log) error {
+		log = item
+		return errLogFindDone
+	}); err == errLogFindDone {
+		err = nil
+	}
+	if err == nil && log == nil {
+		err = common.ErrLogNotFound
+	}
+	return
+}
+
+// InitLogReader 根据主日志存储的配置初始化日志查询
+func InitLogReader() (err error) {
+	switch common.GConfig.LogSink.Primary {
+	case "", "mysql":
+		GLogReader = &mysqlLogReader{}
+	case "mongo":
+		if err = common.InitMongoConn(); err != nil {
+			return
+		}
+		GLogReader = &mongoLogReader{}
+	case "file":
+		GLogReader = &fileLogReader{dir: common.GConfig.LogSink.File.Dir}
+	case "http":
+		err = common.ErrLogSinkNotReadable
+	default:
+		err = common.ErrLogSinkUnknown
+	}
+	return
+}
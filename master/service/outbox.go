@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+)
+
+var (
+	GOutbox *Outbox
+)
+
+// Outbox 任务变更发件箱：接口在修改 mysql 的同一个事务中写入变更记录，由发布协程按顺序发布到 etcd，失败时重试
+type Outbox struct {
+	notifyChan chan struct{}
+	lastPurge  time.Time
+}
+
+// Enqueue 在事务中写入一条任务变更，保存任务时 job 为写入 etcd 的任务，返回变更 id
+func (_self *Outbox) Enqueue(tx *gorm.DB, op int, jobName string, job *common.Job) (mutationID string, err error) {
+	var (
+		payload []byte
+	)
+
+	if job != nil {
+		if payload, err = json.Marshal(job); err != nil {
+			return
+		}
+	}
+	mutationID = uuid.New().String()
+	err = tx.Create(&model.JobMutation{
+		MutationID: mutationID,
+		JobName:    jobName,
+		Op:         op,
+		Payload:    string(payload),
+		Status:     common.MutationStatus["待发布"],
+	}).Error
+	return
+}
+
+// Notify 事务提交后通知发布协程立即发布
+func (_self *Outbox) Notify() {
+	select {
+	case _self.notifyChan <- struct{}{}:
+	default:
+	}
+}
+
+// GetMutation 查询任务变更的发布状态
+func (_self *Outbox) GetMutation(mutationID string) (mutation *model.JobMutation, err error) {
+	mutation = &model.JobMutation{}
+	err = common.GMsql.DB.Where("mutation_id = ?", mutationID).First(mutation).Error
+	return
+}
+
+// PendingJobs 有待发布变更的任务，这些任务 mysql 与 etcd 暂时不一致
+func (_self *Outbox) PendingJobs() (jobNames map[string]bool, err error) {
+	var (
+		names []string
+	)
+
+	if err = common.GMsql.DB.Model(&model.JobMutation{}).Where("status = ?", common.MutationStatus["待发布"]).
+		Distinct("job_name").Pluck("job_name", &names).Error; err != nil {
+		return
+	}
+	jobNames = make(map[string]bool)
+	for _, name := range names {
+		jobNames[name] = true
+	}
+	return
+}
+
+// BuildJob 根据 mysql 中的任务构造写入 etcd 的任务，脚本任务读取当前版本的脚本；db 可以是事务
+func BuildJob(db *gorm.DB, job *model.Job) (etcdJob *common.Job, err error) {
+	var (
+		script model.JobScript
+	)
+
+	etcdJob = &common.Job{
+		Name:           job.Name,
+		Command:        job.Command,
+		CronExpr:       job.CronExpr,
+		Typ:            job.Typ,
+		OutputLimit:    job.OutputLimit,
+		Timeout:        job.Timeout,
+		Kind:           job.Kind,
+		Interpreter:    job.Interpreter,
+		ScriptVersion:  job.ScriptVersion,
+		Task:           job.Task,
+		Params:         job.Params,
+		Secrets:        splitList(job.Secrets),
+		SuccessCodes:   job.SuccessCodes,
+		SuccessMatch:   job.SuccessMatch,
+		FailMatch:      job.FailMatch,
+		WorkDir:        job.WorkDir,
+		Artifacts:      splitList(job.Artifacts),
+		LockLostPolicy: job.LockLostPolicy,
+		Selector:       job.Selector,
+	}
+	if job.Kind == common.JobKind["脚本任务"] {
+		if err = db.Where("job_id = ? AND version = ?", job.ID, job.ScriptVersion).First(&script).Error; err != nil {
+			return
+		}
+		etcdJob.Script = script.Script
+	}
+	return
+}
+
+// 拆分逗号分隔的列表，空字符串返回 nil
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// 在一个 etcd 事务中执行变更并记录变更 id，已经发布过的变更不会重复执行
+func (_self *Outbox) publish(mutation *model.JobMutation) (err error) {
+	var (
+		markKey        string
+		getResp        *clientv3.GetResponse
+		leaseGrantResp *clientv3.LeaseGrantResponse
+		killLeaseResp  *clientv3.LeaseGrantResponse
+		txnResp        *clientv3.TxnResponse
+		op             clientv3.Op
+	)
+
+	// 已经发布过的变更不再申请租约
+	markKey = common.JobMutationDir + mutation.MutationID
+	if getResp, err = GJobSer.kv.Get(context.TODO(), markKey, clientv3.WithCountOnly()); err != nil {
+		return
+	}
+	if getResp.Count > 0 {
+		return
+	}
+
+	switch mutation.Op {
+	case common.MutationOp["保存"]:
+		op = clientv3.OpPut(common.JobSaveDir+mutation.JobName, mutation.Payload)
+	case common.MutationOp["删除"]:
+		op = clientv3.OpDelete(common.JobSaveDir + mutation.JobName)
+	case common.MutationOp["强杀"]:
+		// 让 worker 监听到一次 put 操作，租约稍后自动过期
+		if killLeaseResp, err = GJobSer.lease.Grant(context.TODO(), 1); err != nil {
+			return
+		}
+		op = clientv3.OpPut(common.JobKillerDir+mutation.JobName, "", clientv3.WithLease(killLeaseResp.ID))
+	}
+
+	// 变更 id 保留一天，足够覆盖发布重试的时间
+	if leaseGrantResp, err = GJobSer.lease.Grant(context.TODO(), 86400); err != nil {
+		return
+	}
+	txnResp, err = GJobSer.kv.Txn(context.TODO()).
+		If(clientv3.Compare(clientv3.CreateRevision(markKey), "=", 0)).
+		Then(op, clientv3.OpPut(markKey, "", clientv3.WithLease(leaseGrantResp.ID))).
+		Commit()
+
+	// 事务失败或者检查之后被并发发布了，释放这次申请的租约；
+	// 事务结果不确定时释放租约会删除变更 id，变更稍后重新发布，保存、删除和强杀重复执行都没有影响
+	if err != nil || !txnResp.Succeeded {
+		GJobSer.lease.Revoke(context.TODO(), leaseGrantResp.ID)
+		if killLeaseResp != nil {
+			GJobSer.lease.Revoke(context.TODO(), killLeaseResp.ID)
+		}
+	}
+	return
+}
+
+// 发布一批待发布的变更，同一个任务的变更按顺序发布，前一个失败时后面的等待下次重试
+func (_self *Outbox) publishBatch() (err error) {
+	var (
+		conf      common.OutboxConf
+		mutations []*model.JobMutation
+		blocked   map[string]bool
+		updates   map[string]interface{}
+		now       time.Time
+	)
+
+	conf = common.GConfig.Outbox
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 100
+	}
+	if err = common.GMsql.DB.Where("status = ?", common.MutationStatus["待发布"]).
+		Order("id").Limit(conf.BatchSize).Find(&mutations).Error; err != nil {
+		return
+	}
+
+	blocked = make(map[string]bool)
+	for _, mutation := range mutations {
+		if blocked[mutation.JobName] {
+			continue
+		}
+
+		updates = map[string]interface{}{"attempts": gorm.Expr("attempts + 1")}
+		if publishErr := _self.publish(mutation); publishErr == nil {
+			now = time.Now()
+			updates["status"] = common.MutationStatus["已发布"]
+			updates["published_at"] = &now
+			updates["last_error"] = ""
+		} else {
+			logger.Warn.With("job", mutation.JobName, "mutation_id", mutation.MutationID).Printf("任务变更发布失败: %s ", publishErr)
+			updates["last_error"] = publishErr.Error()
+			if conf.MaxAttempts > 0 && mutation.Attempts+1 >= conf.MaxAttempts {
+				updates["status"] = common.MutationStatus["发布失败"]
+			} else {
+				blocked[mutation.JobName] = true
+			}
+		}
+		if err = common.GMsql.DB.Model(mutation).Updates(updates).Error; err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 发布协程，定时或收到通知后发布
+func (_self *Outbox) publishLoop() {
+	var (
+		err      error
+		interval time.Duration
+	)
+
+	if interval = time.Duration(common.GConfig.Outbox.Interval) * time.Second; interval <= 0 {
+		interval = time.Second
+	}
+
+	for {
+		if err = _self.publishBatch(); err != nil {
+			logger.Error.Printf("发布任务变更失败: %s ", err)
+		}
+
+		// 已发布的变更保留 7 天
+		if time.Since(_self.lastPurge) > time.Hour {
+			if err = common.GMsql.DB.Where("status = ? AND published_at < ?", common.MutationStatus["已发布"], time.Now().AddDate(0, 0, -7)).
+				Delete(&model.JobMutation{}).Error; err != nil {
+				logger.Warn.Printf("清理已发布的任务变更失败: %s ", err)
+			}
+			_self.lastPurge = time.Now()
+		}
+
+		select {
+		case <-_self.notifyChan:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// InitOutbox 初始化任务变更发件箱，启动发布协程，需要在 InitJobSer 之后调用
+func InitOutbox() (err error) {
+	GOutbox = &Outbox{
+		notifyChan: make(chan struct{}, 1),
+	}
+
+	go GOutbox.publishLoop()
+	return
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+)
+
+var (
+	GReconciler *Reconciler
+)
+
+// ReconcileItem 一个不一致的任务
+type ReconcileItem struct {
+	JobName string `json:"job_name"`
+	Kind    string `json:"kind"`          // 不一致的类型，见 common.ReconcileKind
+	Detail  string `json:"detail"`        // 不一致的内容
+	Action  string `json:"action"`        // 执行的修复，只记录时为空
+	Err     string `json:"err,omitempty"` // 修复失败的原因
+}
+
+// ReconcileReport 一次一致性检查的结果
+type ReconcileReport struct {
+	StartTime time.Time        `json:"start_time"`
+	EndTime   time.Time        `json:"end_time"`
+	Checked   int              `json:"checked"`       // 检查的任务数
+	Items     []*ReconcileItem `json:"items"`         // 不一致的任务
+	Err       string           `json:"err,omitempty"` // 检查中断的原因
+}
+
+// Reconciler 对比 mysql jobs 表和 etcd /cron/jobs/，修复或记录两边不一致的任务
+type Reconciler struct {
+	lock       sync.Mutex // 同一时间只执行一次检查
+	lastReport *ReconcileReport
+	revisions  []revisionSample // 历次检查时 etcd 的 revision，用于判断孤立任务是否超过等待时间
+}
+
+// revisionSample 某个时间点 etcd 的 revision
+type revisionSample struct {
+	time     time.Time
+	revision int64
+}
+
+// 记录本次检查的 revision，返回等待时间之前的 revision：修改 revision 不超过它的任务已经存在了足够长的时间
+func (_self *Reconciler) graceRevision(now time.Time, revision int64, grace time.Duration) (graceRevision int64) {
+	var (
+		keep int
+	)
+
+	_self.revisions = append(_self.revisions, revisionSample{time: now, revision: revision})
+	for i, sample := range _self.revisions {
+		if now.Sub(sample.time) >= grace {
+			graceRevision = sample.revision
+			keep = i
+		}
+	}
+	// 只需要保留最近一个超过等待时间的记录
+	_self.revisions = _self.revisions[keep:]
+	return
+}
+
+// LastReport 最近一次检查的结果
+func (_self *Reconciler) LastReport() *ReconcileReport {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+	return _self.lastReport
+}
+
+// Reconcile 执行一次检查
+func (_self *Reconciler) Reconcile() (report *ReconcileReport, err error) {
+	var (
+		jobs           []model.Job
+		etcdJobs       []*common.Job
+		modRevisions   map[string]int64
+		revision       int64
+		orphanRevision int64
+		jobMap         map[string]model.Job
+		etcdMap        map[string]*common.Job
+		pending        map[string]bool
+		workers        []*common.WorkerInfo
+		grace          time.Time
+	)
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	report = &ReconcileReport{StartTime: time.Now(), Items: []*ReconcileItem{}}
+	defer func() {
+		report.EndTime = time.Now()
+		if err != nil {
+			report.Err = err.Error()
+		}
+		_self.lastReport = report
+		logger.Info.Printf("任务一致性检查完成，检查 %d 个任务，%d 个不一致 ", report.Checked, len(report.Items))
+	}()
+
+	// 有待发布变更的任务由发件箱负责同步到 etcd，跳过
+	if pending, err = GOutbox.PendingJobs(); err != nil {
+		return
+	}
+
+	// 先读 etcd 再读 mysql：任务先写入 mysql 再由发件箱发布到 etcd，etcd 中读到的任务在 mysql 中一定已经存在
+	if etcdJobs, modRevisions, revision, err = GJobSer.ListJobRevisions(); err != nil {
+		return
+	}
+	if err = common.GMsql.DB.Find(&jobs).Error; err != nil {
+		return
+	}
+
+	jobMap = make(map[string]model.Job)
+	for _, job := range jobs {
+		jobMap[job.Name] = job
+	}
+	etcdMap = make(map[string]*common.Job)
+	for _, etcdJob := range etcdJobs {
+		etcdMap[etcdJob.Name] = etcdJob
+	}
+	report.Checked = len(jobMap)
+
+	// 最近修改过的任务可能正在保存或删除，跳过；etcd 中的任务没有修改时间，按 revision 判断
+	grace = time.Now().Add(-time.Duration(common.GConfig.Reconcile.Grace) * time.Second)
+	orphanRevision = _self.graceRevision(report.StartTime, revision, time.Duration(common.GConfig.Reconcile.Grace)*time.Second)
+
+	for name, etcdJob := range etcdMap {
+		if pending[name] {
+			continue
+		}
+		if _, ok := jobMap[name]; !ok {
+			if modRevisions[name] > orphanRevision {
+				continue
+			}
+			_self.repair(report, name, common.ReconcileKind["孤立任务"], "etcd 中有任务，mysql 中没有", "从 etcd 删除", func() error {
+				_, err := GJobSer.DeleteJob(name)
+				return err
+			})
+			continue
+		}
+		if job := jobMap[name]; job.UpdatedAt.Before(grace) {
+			_self.checkJob(report, job, etcdJob)
+		}
+	}
+	for name, job := range jobMap {
+		if _, ok := etcdMap[name]; !ok && !pending[name] && job.UpdatedAt.Before(grace) {
+			_self.checkJob(report, job, nil)
+		}
+	}
+
+	// 节点选择器不依赖 mysql 与 etcd 的一致性，etcd 中等待调度的任务都检查
+	if workers, err = GWorkerSer.ListWorkers(); err != nil {
+		return
+	}
+	for _, etcdJob := range etcdMap {
+		_self.checkPlacement(report, etcdJob, workers)
+	}
+	return
+}
+
+// 检查一个任务，etcdJob 为 nil 表示 etcd 中不存在
+func (_self *Reconciler) checkJob(report *ReconcileReport, job model.Job, etcdJob *common.Job) {
+	var (
+		err    error
+		locked bool
+		logs   []*model.Log
+		status int
+	)
+
+	// 任务已删除，或者单次任务已经执行结束，worker 应该已经从 etcd 删除
+	if !JobSchedulable(job) {
+		if etcdJob != nil {
+			_self.repair(report, job.Name, common.ReconcileKind["残留任务"], fmt.Sprintf("任务状态为 %d，etcd 中仍然存在", job.Status), "从 etcd 删除", func() error {
+				_, err := GJobSer.DeleteJob(job.Name)
+				return err
+			})
+		}
+		return
+	}
+
+	if etcdJob == nil {
+		// 单次任务执行后由 worker 从 etcd 删除，状态更新丢失时按最近一次执行结果修正
+		if job.Typ == 1 {
+			if logs, _, err = GLogReader.Find(&common.LogFilter{JobName: job.Name}, 0, 1); err != nil {
+				report.Items = append(report.Items, &ReconcileItem{JobName: job.Name, Kind: common.ReconcileKind["状态过期"], Err: err.Error()})
+				return
+			}
+			if len(logs) > 0 {
+				if status = common.StatusTyp["已完成"]; logs[0].Result != "1" {
+					status = common.StatusTyp["执行异常"]
+				}
+				_self.repair(report, job.Name, common.ReconcileKind["状态过期"], "单次任务已执行，任务状态没有更新", fmt.Sprintf("状态修改为 %d", status), func() error {
+					return updateJobStatus(job.Name, status)
+				})
+				return
+			}
+		}
+		// 发布失败的变更超过了重试次数，或者 etcd 中的任务被误删，以 mysql 为准重新发布
+		_self.repair(report, job.Name, common.ReconcileKind["缺失任务"], "mysql 中任务可以调度，etcd 中不存在", "重新发布到 etcd", func() error {
+			return republishJob(job)
+		})
+		return
+	}
+
+	// 配置不一致时不确定以哪边为准，只记录
+	if job.Command != etcdJob.Command || job.CronExpr != etcdJob.CronExpr || job.Typ != etcdJob.Typ {
+		report.Items = append(report.Items, &ReconcileItem{
+			JobName: job.Name,
+			Kind:    common.ReconcileKind["配置不一致"],
+			Detail: fmt.Sprintf("mysql: %s %s %d; etcd: %s %s %d",
+				job.CronExpr, job.Command, job.Typ, etcdJob.CronExpr, etcdJob.Command, etcdJob.Typ),
+		})
+	}
+
+	// 执行中的任务持有执行锁，没有锁说明执行结束后的状态更新丢失
+	if job.Status == common.StatusTyp["执行中"] {
+		if locked, err = GJobSer.IsJobLocked(job.Name); err != nil {
+			report.Items = append(report.Items, &ReconcileItem{JobName: job.Name, Kind: common.ReconcileKind["状态过期"], Err: err.Error()})
+			return
+		}
+		if !locked {
+			_self.repair(report, job.Name, common.ReconcileKind["状态过期"], "任务状态为执行中，没有 worker 持有执行锁", "状态修改为待执行", func() error {
+				return updateJobStatus(job.Name, common.StatusTyp["待执行"])
+			})
+		}
+	}
+}
+
+// 检查是否有在线 worker 满足任务的节点选择器，只记录
+func (_self *Reconciler) checkPlacement(report *ReconcileReport, etcdJob *common.Job, workers []*common.WorkerInfo) {
+	var (
+		err      error
+		selector common.Selector
+	)
+
+	if etcdJob.Selector == "" {
+		return
+	}
+	if selector, err = common.ParseSelector(etcdJob.Selector); err != nil {
+		report.Items = append(report.Items, &ReconcileItem{JobName: etcdJob.Name, Kind: common.ReconcileKind["无匹配节点"], Err: err.Error()})
+		return
+	}
+	for _, worker := range workers {
+		if selector.Matches(worker.Labels) {
+			return
+		}
+	}
+	report.Items = append(report.Items, &ReconcileItem{
+		JobName: etcdJob.Name,
+		Kind:    common.ReconcileKind["无匹配节点"],
+		Detail:  fmt.Sprintf("没有在线 worker 满足节点选择器 %s", etcdJob.Selector),
+	})
+	logger.Warn.With("job", etcdJob.Name, "selector", etcdJob.Selector).Println("没有在线 worker 满足节点选择器")
+}
+
+// 记录一个不一致的任务，配置了自动修复时执行修复
+func (_self *Reconciler) repair(report *ReconcileReport, jobName string, kind string, detail string, action string, fix func() error) {
+	var (
+		item *ReconcileItem
+	)
+
+	item = &ReconcileItem{JobName: jobName, Kind: kind, Detail: detail}
+	if common.GConfig.Reconcile.Repair {
+		item.Action = action
+		if err := fix(); err != nil {
+			item.Err = err.Error()
+		}
+		logger.Warn.With("job", jobName, "kind", kind).Printf("%s，%s ", detail, action)
+	} else {
+		logger.Warn.With("job", jobName, "kind", kind).Printf("%s，没有开启自动修复 ", detail)
+	}
+	report.Items = append(report.Items, item)
+}
+
+// JobSchedulable 任务是否应该在 etcd 中：没有删除，单次任务还没有执行结束
+func JobSchedulable(job model.Job) bool {
+	if job.Status == common.StatusTyp["已删除"] {
+		return false
+	}
+	return job.Typ == 0 || (job.Status != common.StatusTyp["已完成"] && job.Status != common.StatusTyp["执行异常"])
+}
+
+// 通过发件箱把 mysql 中的任务重新发布到 etcd
+func republishJob(job model.Job) (err error) {
+	var (
+		etcdJob *common.Job
+	)
+
+	if etcdJob, err = BuildJob(common.GMsql.DB, &job); err != nil {
+		return
+	}
+	if _, err = GOutbox.Enqueue(common.GMsql.DB, common.MutationOp["保存"], job.Name, etcdJob); err != nil {
+		return
+	}
+	GOutbox.Notify()
+	return
+}
+
+// 修改 mysql 中的任务状态
+func updateJobStatus(name string, status int) error {
+	return common.GMsql.DB.Model(&model.Job{}).Where("name = ?", name).Update("status", status).Error
+}
+
+// 定时检查协程
+func (_self *Reconciler) reconcileLoop() {
+	var (
+		err error
+	)
+
+	for {
+		time.Sleep(time.Duration(common.GConfig.Reconcile.Interval) * time.Minute)
+		if _, err = _self.Reconcile(); err != nil {
+			logger.Error.Printf("任务一致性检查失败: %s ", err)
+		}
+	}
+}
+
+// InitReconciler 初始化一致性检查，配置了检查间隔时启动定时检查
+func InitReconciler() (err error) {
+	GReconciler = &Reconciler{}
+
+	if common.GConfig.Reconcile.Interval > 0 {
+		go GReconciler.reconcileLoop()
+	}
+	return
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"crontab/master/common"
+	"crontab/master/logger"
+	"crontab/master/model"
+)
+
+var (
+	GReportSer *ReportSer
+)
+
+// ReportSer 把 worker 上报的任务状态、执行事件和执行日志写入数据库，worker 不需要连接数据库
+type ReportSer struct {
+	client  *clientv3.Client
+	kv      clientv3.KV
+	watcher clientv3.Watcher
+}
+
+// ApplyReport 在一个事务中按顺序写入上报的记录，已经写入过的记录跳过；日志按执行 id 去重，与 worker 直接写入数据库时相同
+func (_self *ReportSer) ApplyReport(records []*common.SpoolRecord) error {
+	return common.GMsql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		var (
+			result *gorm.DB
+			key    string
+			job    model.Job
+		)
+
+		for _, record := range records {
+			if key = record.Key; record.Typ == common.SpoolTypLog && record.Log != nil {
+				key = record.Log.ExecutionID
+			}
+			if result = tx.Clauses(clause.Insert{Modifier: "IGNORE"}).Create(&model.SpoolKey{Key: key}); result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			switch {
+			case record.Typ == common.SpoolTypStatus && record.Status != nil:
+				err = applyStatus(tx, record.Status)
+			case record.Typ == common.SpoolTypEvent && record.Event != nil:
+				err = tx.Create(record.Event).Error
+			case record.Typ == common.SpoolTypLog && record.Log != nil:
+				// worker 没有连接数据库，日志对应的任务 id 在这里补充
+				if record.Log.JobID == 0 {
+					job = model.Job{}
+					if tx.Where("name = ?", record.Log.JobName).Select("id").First(&job).Error == nil {
+						record.Log.JobID = int(job.ID)
+					}
+				}
+				err = tx.Create(record.Log).Error
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// 把任务状态写入数据库
+func applyStatus(tx *gorm.DB, status *common.SpoolStatus) error {
+	if status.JobTyp == 0 {
+		// 定时任务
+		return tx.Model(&model.Job{}).Where("name = ?", status.JobName).
+			Updates(map[string]interface{}{
+				"status":    status.StatusTyp,
+				"next_time": status.NextTime,
+				"num":       gorm.Expr("num + ?", common.GetNumField(status.AddNum)), // 对执行过的任务的执行次数进行加 1
+			}).Error
+	}
+
+	// 单次任务
+	return tx.Model(&model.Job{}).Where("name = ?", status.JobName).
+		Updates(map[string]interface{}{
+			"num":       1,
+			"status":    status.StatusTyp,
+			"next_time": status.NextTime,
+		}).Error
+}
+
+// 写入 etcd 中的一条上报记录，写入成功后删除
+func (_self *ReportSer) applyEtcdRecord(key string, value []byte) {
+	var (
+		err    error
+		record common.SpoolRecord
+		retry  int
+	)
+
+	// 无法解析的记录移入 failed 目录；写入数据库失败时按顺序重试，保证任务状态的顺序
+	if err = json.Unmarshal(value, &record); err != nil {
+		logger.Error.With("key", key).Printf("解析上报记录失败，移入 %s: %s ", common.JobReportFailedDir, err)
+		_self.failEtcdRecord(key, value)
+		return
+	}
+	for {
+		if err = _self.ApplyReport([]*common.SpoolRecord{&record}); err == nil {
+			break
+		}
+		// 数据库连接不上时所有记录都会失败，不计入重试次数
+		if common.GMsql.Ping() == nil {
+			retry++
+		}
+		if maxRetry := common.GConfig.Report.MaxRetry; maxRetry > 0 && retry >= maxRetry {
+			logger.Error.With("key", key).Printf("上报记录重试 %d 次仍然写入失败，移入 %s: %s ", retry, common.JobReportFailedDir, err)
+			_self.failEtcdRecord(key, value)
+			return
+		}
+		logger.Error.With("key", key).Printf("上报记录写入数据库失败，5 秒后重试: %s ", err)
+		time.Sleep(5 * time.Second)
+	}
+	if _, err = _self.kv.Delete(context.TODO(), key); err != nil {
+		logger.Warn.With("key", key).Printf("删除上报记录失败: %s ", err)
+	}
+}
+
+// 把上报记录移入 failed 目录，避免一直阻塞后续的记录
+func (_self *ReportSer) failEtcdRecord(key string, value []byte) {
+	var (
+		err error
+	)
+
+	if _, err = _self.kv.Txn(context.TODO()).Then(
+		clientv3.OpPut(common.JobReportFailedDir+strings.TrimPrefix(key, common.JobReportDir), string(value)),
+		clientv3.OpDelete(key),
+	).Commit(); err != nil {
+		logger.Warn.With("key", key).Printf("上报记录移入 failed 目录失败: %s ", err)
+	}
+}
+
+// 消费 worker 写入 /cron/reports/ 的记录：先按写入顺序处理已有记录，再监听新记录
+func (_self *ReportSer) watchReports() (err error) {
+	var (
+		getResp *clientv3.GetResponse
+	)
+
+	if getResp, err = _self.getReports(); err != nil {
+		return
+	}
+	go _self.consumeLoop(getResp)
+	return
+}
+
+// 按创建顺序读取 /cron/reports/ 下已有的记录
+func (_self *ReportSer) getReports() (*clientv3.GetResponse, error) {
+	return _self.kv.Get(context.TODO(), common.JobReportDir, clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+}
+
+// 处理已有记录后从读取时的 revision 开始监听；监听中断时从最后处理的 revision 重新监听，
+// revision 已被压缩时重新读取已有记录，写入成功的记录都已删除，重新读取不会重复也不会漏掉记录
+func (_self *ReportSer) consumeLoop(getResp *clientv3.GetResponse) {
+	var (
+		err       error
+		watchRev  int64
+		watchChan clientv3.WatchChan
+		compacted bool
+	)
+
+	for {
+		if getResp != nil {
+			for _, keypair := range getResp.Kvs {
+				_self.applyEtcdRecord(string(keypair.Key), keypair.Value)
+			}
+			watchRev = getResp.Header.Revision + 1
+			getResp = nil
+		}
+
+		compacted = false
+		ctx, cancelFunc := context.WithCancel(clientv3.WithRequireLeader(context.Background()))
+		watchChan = _self.watcher.Watch(ctx, common.JobReportDir, clientv3.WithRev(watchRev), clientv3.WithPrefix())
+		for watchResp := range watchChan {
+			if err = watchResp.Err(); err != nil {
+				logger.Error.Printf("监听上报记录出错: %s ", err)
+				// 监听的 revision 已被压缩，只能重新读取已有记录
+				compacted = watchResp.CompactRevision != 0
+				break
+			}
+			for _, watchEvent := range watchResp.Events {
+				if watchEvent.Type == mvccpb.PUT {
+					_self.applyEtcdRecord(string(watchEvent.Kv.Key), watchEvent.Kv.Value)
+				}
+				watchRev = watchEvent.Kv.ModRevision + 1
+			}
+		}
+		cancelFunc()
+
+		time.Sleep(time.Second)
+		if !compacted {
+			logger.Warn.Printf("上报记录监听中断，从 revision %d 重新监听 ", watchRev)
+			continue
+		}
+		for {
+			if getResp, err = _self.getReports(); err == nil {
+				break
+			}
+			logger.Error.Printf("读取上报记录失败，5 秒后重试: %s ", err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// 定时清理已经过期的幂等 key
+func (_self *ReportSer) purgeKeysLoop() {
+	var (
+		err error
+	)
+
+	for {
+		if err = common.GMsql.DB.Where("created_at < ?", time.Now().AddDate(0, 0, -7)).Delete(&model.SpoolKey{}).Error; err != nil {
+			logger.Warn.Printf("清理上报 key 失败: %s ", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// InitReportSer 初始化上报处理，同时消费 etcd 中的上报记录
+func InitReportSer() (err error) {
+	var (
+		config clientv3.Config
+		client *clientv3.Client
+	)
+
+	// 初始化配置
+	config = clientv3.Config{
+		Endpoints:   common.GConfig.Etcd.Endpoints,                                // 集群地址
+		DialTimeout: time.Duration(common.GConfig.Etcd.DialTimeout) * time.Second, // 连接超时
+	}
+
+	// 建立连接
+	if client, err = clientv3.New(config); err != nil {
+		return
+	}
+
+	GReportSer = &ReportSer{
+		client:  client,
+		kv:      clientv3.NewKV(client),
+		watcher: clientv3.NewWatcher(client),
+	}
+	if err = GReportSer.watchReports(); err != nil {
+		return
+	}
+	go GReportSer.purgeKeysLoop()
+	return
+}
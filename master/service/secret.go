@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"crontab/master/common"
+)
+
+var (
+	GSecretSer *SecretSer
+)
+
+// SecretSer 密钥管理器，etcd 中只保存密文，worker 执行任务时读取并解密
+type SecretSer struct {
+	client *clientv3.Client
+	kv     clientv3.KV
+}
+
+// SaveSecret 保存密钥密文到 /cron/secrets/密钥名
+func (_self *SecretSer) SaveSecret(name string, encrypted string) (err error) {
+	_, err = _self.kv.Put(context.TODO(), common.JobSecretDir+name, encrypted)
+	return
+}
+
+// DeleteSecret 删除密钥
+func (_self *SecretSer) DeleteSecret(name string) (err error) {
+	_, err = _self.kv.Delete(context.TODO(), common.JobSecretDir+name)
+	return
+}
+
+// InitSecretSer 初始化密钥管理器
+func InitSecretSer() (err error) {
+	var (
+		config clientv3.Config
+		client *clientv3.Client
+	)
+
+	// 初始化配置
+	config = clientv3.Config{
+		Endpoints:   common.GConfig.Etcd.Endpoints,                                // 集群地址
+		DialTimeout: time.Duration(common.GConfig.Etcd.DialTimeout) * time.Second, // 连接超时
+	}
+
+	// 建立连接
+	if client, err = clientv3.New(config); err != nil {
+		return
+	}
+
+	GSecretSer = &SecretSer{
+		client: client,
+		kv:     clientv3.NewKV(client),
+	}
+	return
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -22,11 +23,11 @@ type WorkerSer struct {
 }
 
 // ListWorkers 获取在线worker列表
-func (_self *WorkerSer) ListWorkers() (workerArr []map[string]string, err error) {
+func (_self *WorkerSer) ListWorkers() (workerArr []*common.WorkerInfo, err error) {
 	var (
 		getResp *clientv3.GetResponse
 		kv      *mvccpb.KeyValue
-		ip      string
+		worker  *common.WorkerInfo
 	)
 
 	// 获取目录下所有Kv
@@ -34,16 +35,39 @@ func (_self *WorkerSer) ListWorkers() (workerArr []map[string]string, err error)
 		return
 	}
 
-	// 解析每个节点的IP
+	// 解析每个节点的注册信息
+	workerArr = make([]*common.WorkerInfo, 0, len(getResp.Kvs))
 	for _, kv = range getResp.Kvs {
+		worker = &common.WorkerInfo{}
+		if json.Unmarshal(kv.Value, worker) != nil {
+			// 旧版本 worker 只注册了上线时间
+			worker = &common.WorkerInfo{}
+			worker.ActiveTime, _ = time.ParseInLocation("2006/01/02 15:04:05", string(kv.Value), time.Local)
+		}
 		// kv.Key : /cron/workers/192.168.2.1
-		ip = common.ExtractWorkerIP(string(kv.Key))
-		worker := map[string]string{"ip": ip, "activeTime": string(kv.Value)}
+		worker.IP = common.ExtractWorkerIP(string(kv.Key))
 		workerArr = append(workerArr, worker)
 	}
 	return
 }
 
+// MatchWorkers 获取标签满足选择器的在线 worker
+func (_self *WorkerSer) MatchWorkers(selector common.Selector) (matched []*common.WorkerInfo, err error) {
+	var (
+		workerArr []*common.WorkerInfo
+	)
+
+	if workerArr, err = _self.ListWorkers(); err != nil {
+		return
+	}
+	for _, worker := range workerArr {
+		if selector.Matches(worker.Labels) {
+			matched = append(matched, worker)
+		}
+	}
+	return
+}
+
 func InitWorkerSer() (err error) {
 	var (
 		config clientv3.Config
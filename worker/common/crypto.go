@@ -0,0 +1,74 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+)
+
+// 由配置的密钥派生 AES-256 密钥
+func secretGCM() (gcm cipher.AEAD, err error) {
+	var (
+		key   [32]byte
+		block cipher.Block
+	)
+
+	if GConfig.Secret.Key == "" {
+		err = ErrSecretKeyEmpty
+		return
+	}
+
+	key = sha256.Sum256([]byte(GConfig.Secret.Key))
+	if block, err = aes.NewCipher(key[:]); err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret 使用 AES-GCM 加密密钥值，返回 base64(nonce + 密文)
+func EncryptSecret(plain string) (encrypted string, err error) {
+	var (
+		gcm   cipher.AEAD
+		nonce []byte
+	)
+
+	if gcm, err = secretGCM(); err != nil {
+		return
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	encrypted = base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plain), nil))
+	return
+}
+
+// DecryptSecret 解密 EncryptSecret 加密的密钥值
+func DecryptSecret(encrypted string) (plain string, err error) {
+	var (
+		gcm     cipher.AEAD
+		data    []byte
+		content []byte
+	)
+
+	if gcm, err = secretGCM(); err != nil {
+		return
+	}
+	if data, err = base64.StdEncoding.DecodeString(encrypted); err != nil {
+		return
+	}
+	if len(data) < gcm.NonceSize() {
+		err = ErrSecretCorrupted
+		return
+	}
+	if content, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil); err != nil {
+		return
+	}
+
+	plain = string(content)
+	return
+}
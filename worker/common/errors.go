@@ -5,4 +5,17 @@ import "errors"
 var (
 	ErrLockAlreadyRequired = errors.New("锁已被占用")
 	ErrNoLocalIpFound      = errors.New("没有找到网卡IP")
+	ErrStorageTypUnknown   = errors.New("不支持的存储类型")
+	ErrInterpreterUnknown  = errors.New("不支持的脚本解释器")
+	ErrTaskUnknown         = errors.New("内置任务不存在")
+	ErrLogSinkUnknown      = errors.New("不支持的日志存储类型")
+	ErrLogSinkPrimary      = errors.New("主日志存储必须在 sinks 中")
+	ErrSecretKeyEmpty      = errors.New("没有配置密钥加密 key")
+	ErrSecretCorrupted     = errors.New("密钥密文已损坏")
+	ErrSuccessCodes        = errors.New("成功退出码只能是逗号分隔的整数")
+	ErrSecretNotFound      = errors.New("任务引用的密钥不存在")
+	ErrReportModeUnknown   = errors.New("不支持的上报方式")
+	ErrReportTooLarge      = errors.New("上报记录超过 etcd 请求大小上限")
+	ErrArtifactWorkDir     = errors.New("收集产物的任务必须设置工作目录")
+	ErrSecretReserved      = errors.New("密钥名不能使用 PATH、HOME、LD_*、CRONTAB_* 等保留的环境变量名")
 )
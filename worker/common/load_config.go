@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 
 	"gopkg.in/yaml.v2"
+
+	"crontab/worker/logger"
 )
 
 var (
@@ -17,6 +19,22 @@ type Config struct {
 	MySQL   MySQL
 	MongoDB MongoDB
 	Worker  Worker
+	Storage StorageConf
+	// 任务产物存储，没有配置时使用 Storage
+	ArtifactStorage StorageConf `yaml:"artifact_storage"`
+	Secret          SecretConf
+	// 执行日志存储
+	LogSink LogSinkConf `yaml:"log_sink"`
+	// 执行日志保留策略
+	Retention RetentionConf
+	// 服务自身的日志
+	Log logger.Config
+	// worker 上报任务状态和执行日志的方式
+	Report ReportConf
+	// mysql 与 etcd 任务一致性检查
+	Reconcile ReconcileConf
+	// 任务变更发件箱
+	Outbox OutboxConf
 }
 
 type Http struct {
@@ -62,10 +80,86 @@ type MongoDB struct {
 }
 
 type Worker struct {
-	ScheduleSleep    int    `yaml:"schedule_sleep"`
-	BashPath         string `yaml:"bash_path"`
-	LogBatchSize     int    `yaml:"log_batch_size"`
-	LogCommitTimeout int    `yaml:"log_commit_timeout"`
+	WorkerID         string            `yaml:"worker_id"`
+	ScheduleSleep    int               `yaml:"schedule_sleep"`
+	BashPath         string            `yaml:"bash_path"`
+	LogBatchSize     int               `yaml:"log_batch_size"`
+	LogCommitTimeout int               `yaml:"log_commit_timeout"`
+	OutputTTL        int               `yaml:"output_ttl"`
+	OutputTailChunks int               `yaml:"output_tail_chunks"`
+	OutputLimit      int               `yaml:"output_limit"`
+	KillGrace        int               `yaml:"kill_grace"`
+	Interpreters     map[string]string `yaml:"interpreters"`
+	SpoolDir         string            `yaml:"spool_dir"`
+	SpoolRetry       int               `yaml:"spool_retry"`
+	SpoolMaxRetry    int               `yaml:"spool_max_retry"`
+	MetricsAddr      string            `yaml:"metrics_addr"`
+	Labels           map[string]string `yaml:"labels"`
+	RegisterInterval int               `yaml:"register_interval"`
+}
+
+type LogSinkConf struct {
+	Primary string       `yaml:"primary"`
+	Search  string       `yaml:"search"`
+	Sinks   []string     `yaml:"sinks"`
+	File    FileSinkConf `yaml:"file"`
+	HTTP    HTTPSinkConf `yaml:"http"`
+}
+
+type FileSinkConf struct {
+	Dir      string `yaml:"dir"`
+	MaxSize  int64  `yaml:"max_size"`
+	MaxFiles int    `yaml:"max_files"`
+}
+
+type HTTPSinkConf struct {
+	URL     string            `yaml:"url"`
+	Timeout int               `yaml:"timeout"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+type RetentionConf struct {
+	MaxAgeDays int  `yaml:"max_age_days"`
+	MaxCount   int  `yaml:"max_count"`
+	Interval   int  `yaml:"interval"`
+	BatchSize  int  `yaml:"batch_size"`
+	BatchSleep int  `yaml:"batch_sleep"`
+	Archive    bool `yaml:"archive"`
+}
+
+type OutboxConf struct {
+	Interval    int `yaml:"interval"`
+	BatchSize   int `yaml:"batch_size"`
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+type ReconcileConf struct {
+	Interval int  `yaml:"interval"`
+	Grace    int  `yaml:"grace"`
+	Repair   bool `yaml:"repair"`
+}
+
+type ReportConf struct {
+	Mode      string `yaml:"mode"`
+	MasterURL string `yaml:"master_url"`
+	Token     string `yaml:"token"`
+	Timeout   int    `yaml:"timeout"`
+}
+
+type SecretConf struct {
+	Key string `yaml:"key"`
+}
+
+type StorageConf struct {
+	Typ       string `yaml:"typ"`
+	LocalDir  string `yaml:"local_dir"`
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	UseSSL    bool   `yaml:"use_ssl"`
+	PathStyle bool   `yaml:"path_style"`
 }
 
 // InitConfig 加载配置
@@ -57,6 +57,21 @@ func (_self *MongoMgr) InsertMany(collection string, docs []interface{}) (err er
 	return
 }
 
+// UpsertMany 批量更新或插入，pairs 为 selector、doc 交替排列
+func (_self *MongoMgr) UpsertMany(collection string, pairs []interface{}) (err error) {
+	ms, c := _self.connect(collection)
+	defer ms.Close()
+
+	bulk := c.Bulk()
+	bulk.Unordered()
+	bulk.Upsert(pairs...)
+	if _, err = bulk.Run(); err != nil {
+		logger.Error.Println("执行日志批量写入失败: ", err)
+		return
+	}
+	return
+}
+
 func InitMongoConn() (err error) {
 
 	var (
@@ -3,9 +3,17 @@ package common
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
 	"github.com/gorhill/cronexpr"
 	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"crontab/worker/logger"
@@ -35,6 +43,17 @@ func ExtractKillerName(killerKey string) string {
 	return strings.TrimPrefix(killerKey, JobKillerDir)
 }
 
+// DescribeCommand 执行日志中记录的命令，脚本任务记录解释器和脚本版本，内置任务记录任务名和参数
+func DescribeCommand(job *Job) string {
+	switch job.Kind {
+	case JobKind["脚本任务"]:
+		return fmt.Sprintf("%s 脚本(版本 %d)", job.Interpreter, job.ScriptVersion)
+	case JobKind["内置任务"]:
+		return fmt.Sprintf("内置任务 %s %s", job.Task, job.Params)
+	}
+	return job.Command
+}
+
 // BuildJobEvent 任务变化事件有2种：1）更新任务 2）删除任务
 func BuildJobEvent(eventType int, job *Job) (jobEvent *JobEvent) {
 	return &JobEvent{
@@ -75,9 +94,10 @@ func BuildJobSchedulePlan(job *Job) (jobSchedulePlan *JobSchedulePlan, err error
 // BuildJobExecuteInfo 构造执行状态信息
 func BuildJobExecuteInfo(jobSchedulePlan *JobSchedulePlan) (jobExecuteInfo *JobExecuteInfo) {
 	jobExecuteInfo = &JobExecuteInfo{
-		Job:      jobSchedulePlan.Job,
-		PlanTime: jobSchedulePlan.NextTime, // 计划调度时间
-		RealTime: time.Now(),               // 真实调度时间
+		Job:         jobSchedulePlan.Job,
+		ExecutionID: uuid.New().String(),      // 执行 id
+		PlanTime:    jobSchedulePlan.NextTime, // 计划调度时间
+		RealTime:    time.Now(),               // 真实调度时间
 	}
 	jobExecuteInfo.CancelCtx, jobExecuteInfo.CancelFunc = context.WithCancel(context.TODO())
 	return
@@ -128,3 +148,166 @@ func GetNumField(adm bool) int {
 	}
 	return 0
 }
+
+// GetExitStatus 从进程状态中提取退出码和终止进程的信号，进程没有启动时退出码为 -1
+func GetExitStatus(state *os.ProcessState) (exitCode int, signal string) {
+	var (
+		waitStatus syscall.WaitStatus
+		isWait     bool
+		sig        syscall.Signal
+	)
+
+	if state == nil {
+		exitCode = -1
+		return
+	}
+
+	exitCode = state.ExitCode()
+	if waitStatus, isWait = state.Sys().(syscall.WaitStatus); isWait && waitStatus.Signaled() {
+		sig = waitStatus.Signal()
+		if signal = SignalNames[sig]; signal == "" {
+			signal = sig.String()
+		}
+	}
+	return
+}
+
+// RenderCommand 渲染命令模板，不包含模板语法的命令原样返回
+func RenderCommand(command string, vars *CommandVars) (rendered string, err error) {
+	var (
+		tmpl *template.Template
+		buf  strings.Builder
+	)
+
+	if !strings.Contains(command, "{{") {
+		rendered = command
+		return
+	}
+
+	if tmpl, err = template.New("command").Option("missingkey=error").Parse(command); err != nil {
+		return
+	}
+	if err = tmpl.Execute(&buf, vars); err != nil {
+		return
+	}
+	rendered = buf.String()
+	return
+}
+
+// BuildCommandEnv 把模板变量转换为环境变量
+func BuildCommandEnv(vars *CommandVars) []string {
+	return []string{
+		"CRONTAB_PLAN_TIME=" + vars.PlanTime.Format(time.RFC3339),
+		"CRONTAB_JOB_NAME=" + vars.JobName,
+		"CRONTAB_WORKER_IP=" + vars.WorkerIP,
+		"CRONTAB_EXECUTION_ID=" + vars.ExecutionID,
+	}
+}
+
+// ParseSuccessCodes 解析成功退出码，逗号分隔，为空时只有 0 表示成功
+func ParseSuccessCodes(codes string) (codeMap map[int]bool, err error) {
+	var (
+		code int
+	)
+
+	codeMap = make(map[int]bool)
+	for _, item := range strings.Split(codes, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if code, err = strconv.Atoi(item); err != nil {
+			err = ErrSuccessCodes
+			return
+		}
+		codeMap[code] = true
+	}
+	if len(codeMap) == 0 {
+		codeMap[0] = true
+	}
+	return
+}
+
+// CheckJobResult 按任务的成功条件判断执行结果，返回 nil 表示执行成功；
+// 启动失败、被强杀、超时、内置任务出错等不是由退出码导致的错误，直接视为失败；
+// 成功/失败条件正则匹配的是合并后的输出，输出超过上限时只匹配保留的头部和尾部
+func CheckJobResult(job *Job, result *JobExecuteResult) (err error) {
+	var (
+		isExitErr bool
+		codeMap   map[int]bool
+		re        *regexp.Regexp
+	)
+
+	if result.Err != nil {
+		if _, isExitErr = result.Err.(*exec.ExitError); !isExitErr || result.Signal != "" {
+			return result.Err
+		}
+	}
+
+	if codeMap, err = ParseSuccessCodes(job.SuccessCodes); err != nil {
+		return
+	}
+	if !codeMap[result.ExitCode] {
+		if result.Err != nil {
+			return result.Err
+		}
+		return fmt.Errorf("退出码 %d 不是成功退出码", result.ExitCode)
+	}
+
+	if job.SuccessMatch != "" {
+		if re, err = regexp.Compile(job.SuccessMatch); err != nil {
+			return fmt.Errorf("成功条件正则错误: %s", err)
+		}
+		if !re.Match(result.Output) {
+			return fmt.Errorf("输出没有匹配成功条件: %s", job.SuccessMatch)
+		}
+	}
+	if job.FailMatch != "" {
+		if re, err = regexp.Compile(job.FailMatch); err != nil {
+			return fmt.Errorf("失败条件正则错误: %s", err)
+		}
+		if re.Match(result.Output) {
+			return fmt.Errorf("输出匹配了失败条件: %s", job.FailMatch)
+		}
+	}
+	return
+}
+
+// PackArtifacts 序列化任务产物列表，没有产物时返回空字符串
+func PackArtifacts(artifacts []*JobArtifact) string {
+	var (
+		value []byte
+		err   error
+	)
+
+	if len(artifacts) == 0 {
+		return ""
+	}
+	if value, err = json.Marshal(artifacts); err != nil {
+		logger.Error.Printf("序列化任务产物失败: %s ", err)
+		return ""
+	}
+	return string(value)
+}
+
+// 不能作为密钥名的环境变量，注入后会改变任务的执行环境
+var reservedEnvNames = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "SHELL": true, "PWD": true, "IFS": true,
+	"ENV": true, "BASH_ENV": true, "TMPDIR": true, "LANG": true,
+}
+
+// 不能作为密钥名的环境变量前缀，CRONTAB_ 为任务模板变量
+var reservedEnvPrefixes = []string{"CRONTAB_", "LD_", "DYLD_"}
+
+// IsReservedEnvName 密钥名是否为保留的环境变量名
+func IsReservedEnvName(name string) bool {
+	name = strings.ToUpper(name)
+	if reservedEnvNames[name] {
+		return true
+	}
+	for _, prefix := range reservedEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
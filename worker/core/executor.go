@@ -1,11 +1,19 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"os/exec"
+	"syscall"
 	"time"
 
 	"crontab/worker/common"
+	"crontab/worker/logger"
 )
 
 var (
@@ -21,9 +29,7 @@ func (_self *Executor) ExecuteJob(info *common.JobExecuteInfo) {
 	go func() {
 		var (
 			err     error
-			output  []byte
 			jobLock *JobLock
-			cmd     *exec.Cmd
 			result  *common.JobExecuteResult
 		)
 
@@ -51,21 +57,368 @@ func (_self *Executor) ExecuteJob(info *common.JobExecuteInfo) {
 		} else {
 			// 上锁成功后，重置任务启动时间
 			result.StartTime = time.Now()
+			_self.runJob(info, jobLock, result)
+		}
+		// 任务执行完成后，把执行的结果返回给Scheduler，Scheduler会从executingTable中删除掉执行记录
+		GScheduler.PushJobResult(result)
+	}()
+}
 
-			// 执行shell命令
-			cmd = exec.CommandContext(info.CancelCtx, common.GConfig.Worker.BashPath, "-c", info.Job.Command)
+// runJob 执行任务并把执行结果写入 result
+func (_self *Executor) runJob(info *common.JobExecuteInfo, jobLock *JobLock, result *common.JobExecuteResult) {
+	var (
+		err        error
+		saveErr    error
+		limit      int
+		execCtx    context.Context
+		execCancel context.CancelFunc
+		output     *jobOutput
+		secrets    map[string]string
+		secretArr  []string
+		runDone    chan struct{}
+		watchDone  chan struct{}
+		log        *logger.Entry
+	)
 
-			// 执行并捕获输出
-			output, err = cmd.CombinedOutput()
+	log = jobLogger(info)
 
-			// 记录任务结束时间
-			result.EndTime = time.Now()
-			result.Output = output
-			result.Err = err
+	// 解密任务引用的密钥，任一密钥不可用时不执行任务
+	if secrets, err = _self.loadSecrets(info.Job); err != nil {
+		result.EndTime = time.Now()
+		result.ExitCode = -1
+		result.Err = err
+		return
+	}
+	for _, value := range secrets {
+		secretArr = append(secretArr, value)
+	}
+
+	// 任务设置了超时时间时，超时后和强杀一样结束任务
+	if info.Job.Timeout > 0 {
+		execCtx, execCancel = context.WithTimeout(info.CancelCtx, time.Duration(info.Job.Timeout)*time.Second)
+	} else {
+		execCtx, execCancel = context.WithCancel(info.CancelCtx)
+	}
+	defer execCancel()
+
+	// 执行过程中锁丢失时，按任务的策略标记或者强杀
+	runDone = make(chan struct{})
+	watchDone = make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-runDone:
+		case <-jobLock.Lost():
+			result.LockLost = true
+			result.LockLostTime = time.Now()
+			if info.Job.LockLostPolicy == common.LockLostPolicy["强杀"] {
+				log.Warn.Printf("任务锁丢失，强杀任务 ")
+				GStatusMgr.pushExecutionEvent(info, common.StatusTyp["执行中"], common.EventReason["锁丢失"], "任务锁丢失，强杀任务")
+				execCancel()
+			} else {
+				GStatusMgr.pushExecutionEvent(info, common.StatusTyp["执行中"], common.EventReason["锁丢失"], "任务锁丢失，继续执行")
+			}
 		}
-		// 任务执行完成后，把执行的结果返回给Scheduler，Scheduler会从executingTable中删除掉执行记录
-		GScheduler.PushJobResult(result)
 	}()
+
+	// 输出上限，任务没有单独设置时使用全局配置
+	if limit = info.Job.OutputLimit; limit <= 0 {
+		limit = common.GConfig.Worker.OutputLimit
+	}
+
+	// 捕获输出，同时把输出片段实时推送出去
+	output = newJobOutput(info.Job.Name, limit, secretArr)
+
+	// 执行任务
+	result.Command = common.DescribeCommand(info.Job)
+	GOutputMgr.Begin(info.Job.Name, result.StartTime)
+	if info.Job.Kind == common.JobKind["内置任务"] {
+		err = _self.runTask(context.WithValue(execCtx, taskSecretsKey{}, secrets), info.Job, output, result)
+	} else {
+		err = _self.runProcess(execCtx, info, secrets, output, result)
+	}
+	output.Flush()
+	GOutputMgr.End(info.Job.Name, result.StartTime)
+	close(runDone)
+	<-watchDone
+
+	// 记录任务结束时间
+	result.EndTime = time.Now()
+	result.Output = output.combined.Bytes()
+	result.Stdout = output.stdout.Bytes()
+	result.Stderr = output.stderr.Bytes()
+	result.OutputSize = output.combined.total
+	result.OutputTruncated = output.combined.Truncated()
+	if err != nil && info.CancelCtx.Err() != nil {
+		err = fmt.Errorf("任务被强杀: %s", err)
+		result.Reason = common.EventReason["被强杀"]
+	} else if err != nil && result.LockLost && info.Job.LockLostPolicy == common.LockLostPolicy["强杀"] {
+		err = fmt.Errorf("任务锁丢失，任务被强杀: %s", err)
+		result.Reason = common.EventReason["锁丢失"]
+	} else if err != nil && execCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("任务执行超时(%d 秒): %s", info.Job.Timeout, err)
+		result.Reason = common.EventReason["执行超时"]
+	}
+	// 屏蔽错误信息中的密钥，没有密钥时保留原始错误，用于按退出码判断执行结果
+	if err != nil && output.MaskText(err.Error()) != err.Error() {
+		err = errors.New(output.MaskText(err.Error()))
+	}
+	result.Err = err
+
+	// 输出超过上限时，把完整输出保存到文件存储
+	if result.OutputFile, saveErr = output.Save(
+		fmt.Sprintf("output/%s/%s.log", info.Job.Name, result.StartTime.Format("20060102150405.000")),
+	); saveErr != nil {
+		log.Error.Printf("保存完整输出失败: %s ", saveErr)
+	}
+
+	// 上传任务产物
+	if result.Artifacts, saveErr = uploadArtifacts(info); saveErr != nil {
+		log.Error.Printf("查找任务产物失败: %s ", saveErr)
+	}
+}
+
+// jobLogger 任务执行相关的日志，附带任务名和执行 id
+func jobLogger(info *common.JobExecuteInfo) *logger.Entry {
+	return logger.With("job", info.Job.Name, "execution_id", info.ExecutionID)
+}
+
+// runProcess 以子进程的方式执行命令任务和脚本任务
+func (_self *Executor) runProcess(ctx context.Context, info *common.JobExecuteInfo, secrets map[string]string, output *jobOutput, result *common.JobExecuteResult) (err error) {
+	var (
+		cmd        *exec.Cmd
+		scriptFile string
+		sentSignal syscall.Signal
+		vars       *common.CommandVars
+	)
+
+	// 模板变量，渲染命令的同时作为环境变量传给任务
+	vars = &common.CommandVars{
+		PlanTime:    info.PlanTime,
+		JobName:     info.Job.Name,
+		WorkerIP:    GRegister.localIP,
+		ExecutionID: info.ExecutionID,
+	}
+
+	// 命令任务先渲染命令模板，日志中记录渲染后的命令
+	if info.Job.Kind != common.JobKind["脚本任务"] {
+		if result.Command, err = common.RenderCommand(info.Job.Command, vars); err != nil {
+			result.ExitCode = -1
+			return
+		}
+	}
+
+	// 构造要执行的命令，脚本任务会先把脚本写入临时文件
+	if cmd, scriptFile, err = _self.buildCommand(info.Job, result.Command); err != nil {
+		result.ExitCode = -1
+		return
+	}
+	if scriptFile != "" {
+		defer os.Remove(scriptFile)
+	}
+
+	cmd.Dir = info.Job.WorkDir
+	cmd.Env = append(os.Environ(), common.BuildCommandEnv(vars)...)
+	for name, value := range secrets {
+		// 旧版本允许保存保留名字的密钥，不注入，避免覆盖执行环境
+		if common.IsReservedEnvName(name) {
+			jobLogger(info).Warn.With("secret", name).Println("密钥名为保留的环境变量名，不注入")
+			continue
+		}
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Stdout = output.Writer("stdout")
+	cmd.Stderr = output.Writer("stderr")
+
+	sentSignal, err = _self.runCommand(ctx, cmd, jobLogger(info))
+
+	result.ExitCode, result.Signal = common.GetExitStatus(cmd.ProcessState)
+	// 资源使用情况，包括已回收的子进程
+	if cmd.ProcessState != nil {
+		result.UserCPU = cmd.ProcessState.UserTime()
+		result.SysCPU = cmd.ProcessState.SystemTime()
+		result.MaxRSS = processMaxRSS(cmd.ProcessState)
+	}
+	// 进程捕获了信号后自行退出时，记录 worker 发送的信号
+	if result.Signal == "" && sentSignal != 0 {
+		result.Signal = common.SignalNames[sentSignal]
+	}
+	return
+}
+
+// taskResult 内置任务函数的返回值
+type taskResult struct {
+	output []byte
+	err    error
+}
+
+// runTask 执行内置任务，任务函数在同一进程中运行，无法被强制结束：
+// 任务被强杀或超时后等待 kill_grace 秒，仍未返回时放弃等待并释放任务锁，任务协程在后台自行结束
+func (_self *Executor) runTask(ctx context.Context, job *common.Job, output *jobOutput, result *common.JobExecuteResult) (err error) {
+	var (
+		task      TaskFunc
+		params    json.RawMessage
+		resChan   chan taskResult
+		taskRes   taskResult
+		abandoned bool
+	)
+
+	if task, err = LookupTask(job.Task); err != nil {
+		result.ExitCode = -1
+		return
+	}
+
+	if params = json.RawMessage(job.Params); len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+
+	// 带缓冲，放弃等待后任务协程返回时不会阻塞
+	resChan = make(chan taskResult, 1)
+	go func() {
+		var (
+			res taskResult
+		)
+		res.output, res.err = callTask(ctx, task, params)
+		resChan <- res
+	}()
+
+	select {
+	case taskRes = <-resChan:
+	case <-ctx.Done():
+		select {
+		case taskRes = <-resChan:
+		case <-time.After(time.Duration(common.GConfig.Worker.KillGrace) * time.Second):
+			abandoned = true
+		}
+	}
+	if abandoned {
+		err = fmt.Errorf("内置任务没有响应取消，%d 秒后放弃等待: %s", common.GConfig.Worker.KillGrace, ctx.Err())
+		output.Writer("stderr").Write([]byte(err.Error()))
+		result.ExitCode = -1
+		return
+	}
+
+	output.Writer("stdout").Write(taskRes.output)
+	if err = taskRes.err; err != nil {
+		output.Writer("stderr").Write([]byte(err.Error()))
+		result.ExitCode = 1
+	}
+	return
+}
+
+// loadSecrets 读取并解密任务引用的密钥
+func (_self *Executor) loadSecrets(job *common.Job) (secrets map[string]string, err error) {
+	var (
+		value string
+	)
+
+	secrets = make(map[string]string)
+	for _, name := range job.Secrets {
+		if value, err = GJobMgr.GetSecret(name); err != nil {
+			err = fmt.Errorf("读取密钥 %s 失败: %s", name, err)
+			return
+		}
+		secrets[name] = value
+	}
+	return
+}
+
+// buildCommand 根据任务类型构造命令；脚本任务把脚本写入只有当前用户可读写的临时文件，由调用方负责删除
+func (_self *Executor) buildCommand(job *common.Job, command string) (cmd *exec.Cmd, scriptFile string, err error) {
+	var (
+		interpreter string
+		file        *os.File
+	)
+
+	// 命令任务，command 为渲染后的命令
+	if job.Kind != common.JobKind["脚本任务"] {
+		cmd = exec.Command(common.GConfig.Worker.BashPath, "-c", command)
+		return
+	}
+
+	// 脚本解释器，配置中可以覆盖解释器路径，bash 默认使用 bash_path
+	if _, ok := common.Interpreters[job.Interpreter]; !ok {
+		err = common.ErrInterpreterUnknown
+		return
+	}
+	if interpreter = common.GConfig.Worker.Interpreters[job.Interpreter]; interpreter == "" {
+		if interpreter = common.Interpreters[job.Interpreter]; job.Interpreter == "bash" {
+			interpreter = common.GConfig.Worker.BashPath
+		}
+	}
+
+	// TempFile 创建的文件权限为 0600
+	if file, err = ioutil.TempFile("", "crontab-script-*"); err != nil {
+		return
+	}
+	if _, err = file.WriteString(job.Script); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+
+	scriptFile = file.Name()
+	cmd = exec.Command(interpreter, scriptFile)
+	return
+}
+
+// runCommand 执行命令，任务被强杀或执行超时时，先向整个进程组发送 SIGTERM，
+// 超过宽限期仍未退出再发送 SIGKILL，返回 worker 最后发送的信号
+func (_self *Executor) runCommand(ctx context.Context, cmd *exec.Cmd, log *logger.Entry) (sentSignal syscall.Signal, err error) {
+	var (
+		done       chan struct{}
+		signalChan chan syscall.Signal
+	)
+
+	setProcessGroup(cmd)
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	done = make(chan struct{})
+	signalChan = make(chan syscall.Signal, 1)
+
+	go func() {
+		var (
+			sig     syscall.Signal
+			killErr error
+		)
+		defer func() {
+			signalChan <- sig
+		}()
+
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		// 通知整个进程组退出
+		sig = syscall.SIGTERM
+		if killErr = signalProcessGroup(cmd, sig); killErr != nil {
+			log.Warn.Printf("发送 SIGTERM 失败: %s ", killErr)
+		}
+
+		// 宽限期后仍未退出，强制结束整个进程组
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Duration(common.GConfig.Worker.KillGrace) * time.Second):
+		}
+		sig = syscall.SIGKILL
+		if killErr = signalProcessGroup(cmd, sig); killErr != nil {
+			log.Warn.Printf("发送 SIGKILL 失败: %s ", killErr)
+		}
+	}()
+
+	err = cmd.Wait()
+	close(done)
+	sentSignal = <-signalChan
+	return
 }
 
 // InitExecutor 初始化执行器
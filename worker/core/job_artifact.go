@@ -0,0 +1,114 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"crontab/worker/common"
+)
+
+// 收集产物的工作目录，必须由任务设置：worker 的工作目录被所有任务共用，其中还有 worker 自己的配置文件
+func jobWorkDir(job *common.Job) (workDir string, err error) {
+	if job.WorkDir == "" {
+		err = common.ErrArtifactWorkDir
+		return
+	}
+	return filepath.Abs(job.WorkDir)
+}
+
+// findArtifacts 按任务的 glob 查找产物文件，只返回工作目录内的普通文件，返回相对于工作目录的路径
+func findArtifacts(workDir string, patterns []string) (names []string, err error) {
+	var (
+		matches []string
+		name    string
+		info    os.FileInfo
+		found   map[string]bool
+	)
+
+	found = make(map[string]bool)
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if filepath.IsAbs(pattern) {
+			err = fmt.Errorf("产物路径必须相对于工作目录: %s", pattern)
+			return
+		}
+		if matches, err = filepath.Glob(filepath.Join(workDir, pattern)); err != nil {
+			return
+		}
+		for _, match := range matches {
+			// 避免 ../ 跳出工作目录
+			if name, err = filepath.Rel(workDir, match); err != nil {
+				return
+			}
+			if name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+				continue
+			}
+			if info, err = os.Stat(match); err != nil {
+				return
+			}
+			if !info.Mode().IsRegular() || found[name] {
+				continue
+			}
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// uploadArtifacts 把任务产物上传到产物存储，单个文件上传失败时记录在产物信息中，不影响任务结果
+func uploadArtifacts(info *common.JobExecuteInfo) (artifacts []*common.JobArtifact, err error) {
+	var (
+		workDir  string
+		names    []string
+		artifact *common.JobArtifact
+	)
+
+	if len(info.Job.Artifacts) == 0 {
+		return
+	}
+	if workDir, err = jobWorkDir(info.Job); err != nil {
+		return
+	}
+	if names, err = findArtifacts(workDir, info.Job.Artifacts); err != nil {
+		return
+	}
+
+	for _, name := range names {
+		artifact = &common.JobArtifact{
+			Name: filepath.ToSlash(name),
+			Key:  fmt.Sprintf("artifacts/%s/%s/%s", info.Job.Name, info.ExecutionID, filepath.ToSlash(name)),
+		}
+		if artifact.Size, err = uploadArtifact(filepath.Join(workDir, name), artifact.Key); err != nil {
+			jobLogger(info).Error.With("artifact", name).Printf("上传产物失败: %s ", err)
+			artifact.Err = err.Error()
+			err = nil
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return
+}
+
+// 上传一个产物文件
+func uploadArtifact(filePath string, key string) (size int64, err error) {
+	var (
+		file *os.File
+		info os.FileInfo
+	)
+
+	if file, err = os.Open(filePath); err != nil {
+		return
+	}
+	defer file.Close()
+
+	if info, err = file.Stat(); err != nil {
+		return
+	}
+	size = info.Size()
+	err = common.GArtifactStorage.Put(key, file, size)
+	return
+}
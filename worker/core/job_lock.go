@@ -19,6 +19,12 @@ type JobLock struct {
 	cancelFunc context.CancelFunc // 用于终止自动续租
 	leaseId    clientv3.LeaseID   // 租约ID
 	isLocked   bool               // 是否上锁成功
+	lostChan   chan struct{}      // 自动续租意外停止(租约过期)时关闭
+}
+
+// Lost 锁丢失通知，上锁成功后续租意外停止时关闭；锁丢失后其他 worker 可能抢到锁并执行同一任务
+func (_self *JobLock) Lost() <-chan struct{} {
+	return _self.lostChan
 }
 
 // TryLock 尝试上锁
@@ -37,7 +43,7 @@ func (_self *JobLock) TryLock() (err error) {
 
 	// 1, 创建租约(5秒)
 	if leaseGrantResp, err = _self.lease.Grant(context.TODO(), 5); err != nil {
-		logger.Error.Printf("创建 etcd 租约失败: ", err)
+		logger.Error.Printf("创建 etcd 租约失败: %s ", err)
 		return
 	}
 
@@ -63,6 +69,11 @@ func (_self *JobLock) TryLock() (err error) {
 			}
 		}
 	END:
+		// 不是主动取消的续租，说明租约已经过期(如与 etcd 断开连接)，锁已经丢失
+		if cancelCtx.Err() == nil {
+			logger.Warn.With("job", _self.jobName).Printf("任务锁续租失败，锁已丢失 ")
+			close(_self.lostChan)
+		}
 	}()
 
 	// 4, 创建事务txn
@@ -96,7 +107,7 @@ func (_self *JobLock) TryLock() (err error) {
 FAIL:
 	cancelFunc()                                // 取消自动续租
 	_self.lease.Revoke(context.TODO(), leaseId) //  释放租约
-	logger.Warn.Printf("抢锁失败 ", err)
+	logger.Warn.With("job", _self.jobName).Printf("抢锁失败: %s ", err)
 	return
 }
 
@@ -111,9 +122,10 @@ func (_self *JobLock) Unlock() {
 // InitJobLock 初始化一把锁
 func InitJobLock(jobName string, kv clientv3.KV, lease clientv3.Lease) (jobLock *JobLock) {
 	jobLock = &JobLock{
-		kv:      kv,
-		lease:   lease,
-		jobName: jobName,
+		kv:       kv,
+		lease:    lease,
+		jobName:  jobName,
+		lostChan: make(chan struct{}),
 	}
 	return
 }
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -44,7 +45,7 @@ func (_self *JobMgr) watchJobs() (err error) {
 		return
 	}
 
-	logger.Debug.Printf("etcd 中共 %s 个任务待同步 ", len(getResp.Kvs))
+	logger.Debug.Printf("etcd 中共 %d 个任务待同步 ", len(getResp.Kvs))
 	// 当前有哪些任务
 	for _, keypair = range getResp.Kvs {
 		// 反序列化 json 得到 Job
@@ -132,7 +133,7 @@ func (_self *JobMgr) DeleteJob(name string) (oldJob *common.Job, err error) {
 
 	// 从etcd中删除它
 	if delResp, err = _self.kv.Delete(context.TODO(), jobKey, clientv3.WithPrevKV()); err != nil {
-		logger.Error.Printf("刪除 etcd 中任务失败: %s ", err)
+		logger.Error.With("job", name).Printf("刪除 etcd 中任务失败: %s ", err)
 		return
 	}
 
@@ -140,7 +141,7 @@ func (_self *JobMgr) DeleteJob(name string) (oldJob *common.Job, err error) {
 	if len(delResp.PrevKvs) != 0 {
 		// 解析一下旧值, 返回它
 		if err = json.Unmarshal(delResp.PrevKvs[0].Value, &oldJobObj); err != nil {
-			logger.Error.Printf("刪除 etcd 中任务失败: %s ", err)
+			logger.Error.With("job", name).Printf("刪除 etcd 中任务失败: %s ", err)
 			return
 		}
 		oldJob = &oldJobObj
@@ -148,6 +149,67 @@ func (_self *JobMgr) DeleteJob(name string) (oldJob *common.Job, err error) {
 	return
 }
 
+// 监听 worker 日志级别，master 修改后立即生效，删除后恢复为配置文件中的级别
+func (_self *JobMgr) watchLogLevel() {
+	var (
+		getResp    *clientv3.GetResponse
+		err        error
+		watchChan  clientv3.WatchChan
+		watchResp  clientv3.WatchResponse
+		watchEvent *clientv3.Event
+		level      string
+	)
+
+	if getResp, err = _self.kv.Get(context.TODO(), common.WorkerLogLevelKey); err != nil {
+		logger.Error.Printf("读取日志级别失败: %s ", err)
+		return
+	}
+	if len(getResp.Kvs) > 0 {
+		_self.setLogLevel(string(getResp.Kvs[0].Value))
+	}
+
+	go func() {
+		watchChan = _self.watcher.Watch(context.TODO(), common.WorkerLogLevelKey, clientv3.WithRev(getResp.Header.Revision+1))
+		for watchResp = range watchChan {
+			for _, watchEvent = range watchResp.Events {
+				level = common.GConfig.Log.Level
+				if watchEvent.Type == mvccpb.PUT {
+					level = string(watchEvent.Kv.Value)
+				}
+				_self.setLogLevel(level)
+			}
+		}
+	}()
+}
+
+// 修改日志级别，为空时使用默认级别
+func (_self *JobMgr) setLogLevel(level string) {
+	if level == "" {
+		level = "info"
+	}
+	if err := logger.SetLevel(level); err != nil {
+		logger.Error.Printf("修改日志级别失败: %s ", err)
+		return
+	}
+	logger.Info.Printf("日志级别修改为 %s ", logger.GetLevel())
+}
+
+// GetSecret 读取并解密密钥
+func (_self *JobMgr) GetSecret(name string) (value string, err error) {
+	var (
+		getResp *clientv3.GetResponse
+	)
+
+	if getResp, err = _self.kv.Get(context.TODO(), common.JobSecretDir+name); err != nil {
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		err = fmt.Errorf("%s: %s", common.ErrSecretNotFound, name)
+		return
+	}
+	return common.DecryptSecret(string(getResp.Kvs[0].Value))
+}
+
 // CreateJobLock 创建任务执行锁
 func (_self *JobMgr) CreateJobLock(jobName string) (jobLock *JobLock) {
 	jobLock = InitJobLock(jobName, _self.kv, _self.lease)
@@ -195,5 +257,8 @@ func InitJobMgr() (err error) {
 	// 启动监听killer
 	GJobMgr.watchKiller()
 
+	// 启动监听日志级别
+	GJobMgr.watchLogLevel()
+
 	return
 }
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"crontab/worker/common"
+)
+
+// cappedBuffer 有上限的输出缓冲区，超过上限后只保留头部和尾部
+type cappedBuffer struct {
+	limit int    // 上限(字节)，0 表示不限制
+	head  []byte // 头部输出
+	tail  []byte // 尾部输出
+	total int64  // 完整输出的大小
+}
+
+// Write 追加输出
+func (_self *cappedBuffer) Write(p []byte) {
+	var (
+		headLimit int
+		tailLimit int
+		n         int
+	)
+
+	_self.total += int64(len(p))
+	if _self.limit <= 0 {
+		_self.head = append(_self.head, p...)
+		return
+	}
+
+	// 先填满头部
+	headLimit = _self.limit / 2
+	if n = headLimit - len(_self.head); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		_self.head = append(_self.head, p[:n]...)
+		p = p[n:]
+	}
+
+	// 剩余部分放入尾部，尾部超过两倍上限时才丢弃旧数据，避免频繁拷贝
+	tailLimit = _self.limit - headLimit
+	_self.tail = append(_self.tail, p...)
+	if len(_self.tail) > 2*tailLimit {
+		_self.tail = append(_self.tail[:0], _self.tail[len(_self.tail)-tailLimit:]...)
+	}
+}
+
+// Truncated 输出是否超过上限
+func (_self *cappedBuffer) Truncated() bool {
+	return _self.limit > 0 && _self.total > int64(_self.limit)
+}
+
+// Bytes 返回保留的输出，被截断时在头部和尾部之间插入省略提示
+func (_self *cappedBuffer) Bytes() []byte {
+	var (
+		tail    []byte
+		omitted int64
+		buf     []byte
+	)
+
+	if !_self.Truncated() {
+		return append(append([]byte{}, _self.head...), _self.tail...)
+	}
+
+	tail = _self.tail
+	if len(tail) > _self.limit-_self.limit/2 {
+		tail = tail[len(tail)-(_self.limit-_self.limit/2):]
+	}
+	omitted = _self.total - int64(len(_self.head)) - int64(len(tail))
+
+	buf = append(buf, _self.head...)
+	buf = append(buf, fmt.Sprintf("\n\n...... 输出超过上限，省略 %d 字节 ......\n\n", omitted)...)
+	buf = append(buf, tail...)
+	return buf
+}
+
+// secretMask 密钥值在输出中的替换文本
+const secretMask = "******"
+
+// secretMasker 把输出中的密钥值替换为 ******，密钥值可能被拆分在两次写入中，
+// 末尾可能是密钥开头的部分会暂存到下一次写入时再处理
+type secretMasker struct {
+	secrets  []string
+	replacer *strings.Replacer
+	pending  []byte
+}
+
+// Mask 返回可以输出的部分
+func (_self *secretMasker) Mask(p []byte) []byte {
+	var (
+		data []byte
+		hold int
+	)
+
+	if _self.replacer == nil {
+		return p
+	}
+
+	data = append(_self.pending, p...)
+	hold = _self.holdLen(data)
+	_self.pending = append([]byte{}, data[len(data)-hold:]...)
+	return []byte(_self.replacer.Replace(string(data[:len(data)-hold])))
+}
+
+// Flush 输出结束，返回暂存的部分
+func (_self *secretMasker) Flush() []byte {
+	var (
+		data []byte
+	)
+
+	data, _self.pending = _self.pending, nil
+	if _self.replacer == nil || len(data) == 0 {
+		return data
+	}
+	return []byte(_self.replacer.Replace(string(data)))
+}
+
+// 末尾可能是某个密钥开头的最长长度
+func (_self *secretMasker) holdLen(data []byte) (hold int) {
+	var (
+		n int
+	)
+
+	for _, secret := range _self.secrets {
+		if n = len(secret) - 1; n > len(data) {
+			n = len(data)
+		}
+		for ; n > hold; n-- {
+			if strings.HasPrefix(secret, string(data[len(data)-n:])) {
+				hold = n
+				break
+			}
+		}
+	}
+	return
+}
+
+// newSecretMasker 创建密钥屏蔽器
+func newSecretMasker(secrets []string) *secretMasker {
+	var (
+		masker    *secretMasker
+		oldNewArr []string
+	)
+
+	masker = &secretMasker{}
+	for _, secret := range secrets {
+		if secret != "" {
+			masker.secrets = append(masker.secrets, secret)
+		}
+	}
+	if len(masker.secrets) == 0 {
+		return masker
+	}
+
+	// 较长的密钥优先替换
+	sort.Slice(masker.secrets, func(i, j int) bool {
+		return len(masker.secrets[i]) > len(masker.secrets[j])
+	})
+	for _, secret := range masker.secrets {
+		oldNewArr = append(oldNewArr, secret, secretMask)
+	}
+	masker.replacer = strings.NewReplacer(oldNewArr...)
+	return masker
+}
+
+// jobOutput 一次任务执行的输出
+type jobOutput struct {
+	jobName   string
+	limit     int
+	lock      sync.Mutex    // stdout 和 stderr 会被两个协程同时写入
+	combined  *cappedBuffer // 合并后的输出，与 CombinedOutput 保持一致
+	stdout    *cappedBuffer // 标准输出
+	stderr    *cappedBuffer // 标准错误输出
+	spillFile *os.File      // 超过上限后，完整输出写入的临时文件
+	spillErr  error
+	maskers   map[string]*secretMasker // 每个输出流的密钥屏蔽器
+}
+
+// Writer 获取输出流的写入器
+func (_self *jobOutput) Writer(stream string) io.Writer {
+	return &outputWriter{output: _self, stream: stream}
+}
+
+// 写入输出
+func (_self *jobOutput) write(stream string, p []byte) {
+	_self.lock.Lock()
+	p = _self.maskers[stream].Mask(p)
+	_self.store(stream, p)
+	_self.lock.Unlock()
+
+	if len(p) > 0 {
+		GOutputMgr.Push(_self.jobName, stream, p)
+	}
+}
+
+// 保存屏蔽密钥后的输出，调用方需要持有锁
+func (_self *jobOutput) store(stream string, p []byte) {
+	// 第一次超过上限时创建临时文件，并写入此前的完整输出
+	if _self.limit > 0 && _self.spillFile == nil && _self.spillErr == nil &&
+		_self.combined.total+int64(len(p)) > int64(_self.limit) {
+		if _self.spillFile, _self.spillErr = ioutil.TempFile("", "crontab-output-*.log"); _self.spillErr == nil {
+			_, _self.spillErr = _self.spillFile.Write(_self.combined.Bytes())
+		}
+	}
+	if _self.spillFile != nil && _self.spillErr == nil {
+		_, _self.spillErr = _self.spillFile.Write(p)
+	}
+
+	_self.combined.Write(p)
+	if stream == "stdout" {
+		_self.stdout.Write(p)
+	} else {
+		_self.stderr.Write(p)
+	}
+}
+
+// Flush 输出结束，写入屏蔽密钥时暂存的输出
+func (_self *jobOutput) Flush() {
+	for _, stream := range []string{"stdout", "stderr"} {
+		_self.lock.Lock()
+		p := _self.maskers[stream].Flush()
+		_self.store(stream, p)
+		_self.lock.Unlock()
+
+		if len(p) > 0 {
+			GOutputMgr.Push(_self.jobName, stream, p)
+		}
+	}
+}
+
+// MaskText 屏蔽文本中的密钥值，用于错误信息等不经过输出流的文本
+func (_self *jobOutput) MaskText(text string) string {
+	if replacer := _self.maskers["stdout"].replacer; replacer != nil {
+		return replacer.Replace(text)
+	}
+	return text
+}
+
+// Save 把完整输出保存到文件存储，输出没有超过上限时不保存，返回保存的文件 key
+func (_self *jobOutput) Save(key string) (outputFile string, err error) {
+	var (
+		info os.FileInfo
+	)
+
+	if _self.spillFile == nil {
+		err = _self.spillErr
+		return
+	}
+	defer os.Remove(_self.spillFile.Name())
+	defer _self.spillFile.Close()
+
+	if err = _self.spillErr; err != nil {
+		return
+	}
+	if _, err = _self.spillFile.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if info, err = _self.spillFile.Stat(); err != nil {
+		return
+	}
+	if err = common.GStorage.Put(key, _self.spillFile, info.Size()); err != nil {
+		return
+	}
+	outputFile = key
+	return
+}
+
+// newJobOutput 创建任务输出，limit 为输出上限，0 表示不限制，secrets 为需要在输出中屏蔽的密钥值
+func newJobOutput(jobName string, limit int, secrets []string) *jobOutput {
+	return &jobOutput{
+		jobName:  jobName,
+		limit:    limit,
+		combined: &cappedBuffer{limit: limit},
+		stdout:   &cappedBuffer{limit: limit},
+		stderr:   &cappedBuffer{limit: limit},
+		maskers: map[string]*secretMasker{
+			"stdout": newSecretMasker(secrets),
+			"stderr": newSecretMasker(secrets),
+		},
+	}
+}
+
+// outputWriter 任务输出写入器，保存输出的同时实时推送输出片段
+type outputWriter struct {
+	output *jobOutput
+	stream string // 输出流 stdout / stderr
+}
+
+func (_self *outputWriter) Write(p []byte) (n int, err error) {
+	_self.output.write(_self.stream, p)
+	return len(p), nil
+}
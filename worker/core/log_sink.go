@@ -0,0 +1,260 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"crontab/worker/common"
+	"crontab/worker/model"
+)
+
+var (
+	GLogSinks []LogSink
+)
+
+// LogSink 执行日志存储，日志按批次写入；spool 重放时同一批日志可能被再次写入，实现时需要尽量按 execution_id 去重
+type LogSink interface {
+	Name() string                      // 存储名，与配置中的名字一致
+	WriteLogs(logs []*model.Log) error // 批量写入日志
+}
+
+// mysqlSink 写入 mysql log 表，通过 spool_keys 表去重
+type mysqlSink struct {
+}
+
+func (_self *mysqlSink) Name() string {
+	return "mysql"
+}
+
+func (_self *mysqlSink) WriteLogs(logs []*model.Log) error {
+	return common.GMsql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		var (
+			newLogs []*model.Log
+			result  *gorm.DB
+		)
+
+		for _, log := range logs {
+			if result = tx.Clauses(clause.Insert{Modifier: "IGNORE"}).Create(&model.SpoolKey{Key: log.ExecutionID}); result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				newLogs = append(newLogs, log)
+			}
+		}
+		if len(newLogs) == 0 {
+			return
+		}
+		return tx.Model(&model.Log{}).Create(&newLogs).Error
+	})
+}
+
+// mongoSink 写入 mongodb，按 execution_id 更新或插入
+type mongoSink struct {
+}
+
+func (_self *mongoSink) Name() string {
+	return "mongo"
+}
+
+func (_self *mongoSink) WriteLogs(logs []*model.Log) error {
+	var (
+		pairs []interface{}
+	)
+
+	for _, log := range logs {
+		pairs = append(pairs, bson.M{"executionid": log.ExecutionID}, log)
+	}
+	return common.GMgo.UpsertMany(common.LogCollection, pairs)
+}
+
+// fileSink 写入本地 JSONL 文件，文件超过大小上限后滚动，只保留最近的若干个历史文件
+type fileSink struct {
+	conf common.FileSinkConf
+}
+
+// 日志文件名前缀，当前文件为 crontab-log.jsonl，历史文件为 crontab-log-时间.jsonl
+const logFilePrefix = "crontab-log"
+
+func (_self *fileSink) Name() string {
+	return "file"
+}
+
+func (_self *fileSink) WriteLogs(logs []*model.Log) (err error) {
+	var (
+		buf  bytes.Buffer
+		line []byte
+		file *os.File
+	)
+
+	for _, log := range logs {
+		if line, err = json.Marshal(log); err != nil {
+			return
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err = _self.rotate(int64(buf.Len())); err != nil {
+		return
+	}
+	if file, err = os.OpenFile(filepath.Join(_self.conf.Dir, logFilePrefix+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	if _, err = file.Write(buf.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// 当前文件写入后会超过大小上限时滚动
+func (_self *fileSink) rotate(size int64) (err error) {
+	var (
+		current string
+		info    os.FileInfo
+		history []string
+	)
+
+	current = filepath.Join(_self.conf.Dir, logFilePrefix+".jsonl")
+	if info, err = os.Stat(current); err != nil {
+		if os.IsNotExist(err) {
+			err = os.MkdirAll(_self.conf.Dir, 0755)
+		}
+		return
+	}
+	if _self.conf.MaxSize <= 0 || info.Size() == 0 || info.Size()+size <= _self.conf.MaxSize {
+		return
+	}
+
+	if err = os.Rename(current, filepath.Join(_self.conf.Dir,
+		fmt.Sprintf("%s-%s.jsonl", logFilePrefix, time.Now().Format("20060102150405.000000000")))); err != nil {
+		return
+	}
+
+	// 删除超出数量的历史文件
+	if history, err = filepath.Glob(filepath.Join(_self.conf.Dir, logFilePrefix+"-*.jsonl")); err != nil {
+		return
+	}
+	sort.Strings(history)
+	if _self.conf.MaxFiles > 0 && len(history) > _self.conf.MaxFiles {
+		for _, name := range history[:len(history)-_self.conf.MaxFiles] {
+			if err = os.Remove(name); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// httpSink 把每批日志以 JSON 数组 POST 到配置的地址
+type httpSink struct {
+	conf   common.HTTPSinkConf
+	client *http.Client
+}
+
+func (_self *httpSink) Name() string {
+	return "http"
+}
+
+func (_self *httpSink) WriteLogs(logs []*model.Log) (err error) {
+	var (
+		body    []byte
+		req     *http.Request
+		resp    *http.Response
+		content []byte
+	)
+
+	if body, err = json.Marshal(logs); err != nil {
+		return
+	}
+	if req, err = http.NewRequest(http.MethodPost, _self.conf.URL, bytes.NewReader(body)); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range _self.conf.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if resp, err = _self.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		content, _ = ioutil.ReadAll(resp.Body)
+		err = fmt.Errorf("日志接口返回错误: %s %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	return
+}
+
+// InitLogSinks 根据配置创建日志存储，没有配置时只写入 mysql
+func InitLogSinks() (err error) {
+	var (
+		conf    common.LogSinkConf
+		names   []string
+		primary bool
+		sink    LogSink
+	)
+
+	conf = common.GConfig.LogSink
+	if conf.Primary == "" {
+		conf.Primary = "mysql"
+	}
+	if names = conf.Sinks; len(names) == 0 {
+		names = []string{conf.Primary}
+	}
+
+	GLogSinks = nil
+	for _, name := range names {
+		switch name {
+		case "mysql":
+			// 上报模式下 worker 不连接数据库，日志随上报记录由 master 写入
+			if GReporter != nil {
+				reportLogs = true
+				primary = primary || name == conf.Primary
+				continue
+			}
+			sink = &mysqlSink{}
+		case "mongo":
+			if common.GMgo == nil {
+				if err = common.InitMongoConn(); err != nil {
+					return
+				}
+			}
+			sink = &mongoSink{}
+		case "file":
+			sink = &fileSink{conf: conf.File}
+		case "http":
+			sink = &httpSink{
+				conf:   conf.HTTP,
+				client: &http.Client{Timeout: time.Duration(conf.HTTP.Timeout) * time.Second},
+			}
+		default:
+			err = fmt.Errorf("%s: %s", common.ErrLogSinkUnknown, name)
+			return
+		}
+		if name == conf.Primary {
+			primary = true
+		}
+		GLogSinks = append(GLogSinks, sink)
+	}
+
+	if !primary {
+		err = common.ErrLogSinkPrimary
+	}
+	return
+}
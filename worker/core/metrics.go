@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+
+	"crontab/worker/common"
+	"crontab/worker/logger"
+)
+
+// 输出 worker 指标，使用 Prometheus 文本格式
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var (
+		records  int64
+		segments int
+		bytes    int64
+	)
+
+	records, segments, bytes = GSpool.Size()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP crontab_worker_spool_records 本地 spool 中等待写入数据库的记录数\n")
+	fmt.Fprintf(w, "# TYPE crontab_worker_spool_records gauge\n")
+	fmt.Fprintf(w, "crontab_worker_spool_records %d\n", records)
+	fmt.Fprintf(w, "# HELP crontab_worker_spool_segments 本地 spool 文件数\n")
+	fmt.Fprintf(w, "# TYPE crontab_worker_spool_segments gauge\n")
+	fmt.Fprintf(w, "crontab_worker_spool_segments %d\n", segments)
+	fmt.Fprintf(w, "# HELP crontab_worker_spool_bytes 本地 spool 文件总大小(字节)\n")
+	fmt.Fprintf(w, "# TYPE crontab_worker_spool_bytes gauge\n")
+	fmt.Fprintf(w, "crontab_worker_spool_bytes %d\n", bytes)
+}
+
+// InitMetrics 启动指标接口 GET /metrics，没有配置 metrics_addr 时不启动
+func InitMetrics() (err error) {
+	var (
+		mux *http.ServeMux
+	)
+
+	if common.GConfig.Worker.MetricsAddr == "" {
+		return
+	}
+
+	mux = http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	go func() {
+		if err := http.ListenAndServe(common.GConfig.Worker.MetricsAddr, mux); err != nil {
+			logger.Error.Printf("指标接口启动失败: %s ", err)
+		}
+	}()
+	return
+}
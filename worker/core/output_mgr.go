@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"crontab/worker/common"
+	"crontab/worker/logger"
+)
+
+var (
+	GOutputMgr *OutputMgr
+)
+
+// 输出片段最多占用的队列长度，剩余的位置留给开始和结束事件
+const outputReserved = 100
+
+const (
+	outputEventBegin = iota // 任务开始输出
+	outputEventData         // 输出片段
+	outputEventEnd          // 任务输出结束
+)
+
+// outputEvent 任务输出事件
+type outputEvent struct {
+	typ       int
+	jobName   string
+	startTime time.Time
+	chunk     *common.JobOutputChunk
+}
+
+// OutputMgr 任务实时输出管理，把执行中任务的输出片段推送到 redis，供 master 实时查看
+type OutputMgr struct {
+	eventChan chan *outputEvent
+}
+
+// Begin 任务开始执行，清空上一轮的输出
+func (_self *OutputMgr) Begin(jobName string, startTime time.Time) {
+	_self.send(&outputEvent{typ: outputEventBegin, jobName: jobName, startTime: startTime})
+}
+
+// Push 推送输出片段
+func (_self *OutputMgr) Push(jobName string, stream string, data []byte) {
+	// 给开始和结束事件留出位置，redis 慢时输出片段不会占满队列
+	if len(_self.eventChan) >= cap(_self.eventChan)-outputReserved {
+		return
+	}
+	select {
+	case _self.eventChan <- &outputEvent{
+		typ:     outputEventData,
+		jobName: jobName,
+		chunk:   &common.JobOutputChunk{Stream: stream, Data: string(data)},
+	}:
+	default:
+		// 队列满了就丢弃，实时输出不影响最终日志
+	}
+}
+
+// End 任务执行结束
+func (_self *OutputMgr) End(jobName string, startTime time.Time) {
+	_self.send(&outputEvent{typ: outputEventEnd, jobName: jobName, startTime: startTime})
+}
+
+// 发送开始和结束事件，队列满时丢弃，实时输出不能阻塞任务执行
+func (_self *OutputMgr) send(event *outputEvent) {
+	select {
+	case _self.eventChan <- event:
+	default:
+		logger.Warn.With("job", event.jobName).Printf("实时输出队列已满，丢弃任务输出状态 ")
+	}
+}
+
+// 设置输出状态
+func (_self *OutputMgr) setState(jobName string, status string, startTime time.Time) (err error) {
+	var (
+		stateValue []byte
+	)
+
+	if stateValue, err = json.Marshal(&common.JobOutputState{
+		Status:    status,
+		StartTime: startTime.UnixNano() / int64(time.Millisecond),
+	}); err != nil {
+		return
+	}
+	return common.GRdb.RDB.Set(context.TODO(), common.JobOutputStateDir+jobName, stateValue, _self.ttl()).Err()
+}
+
+// 输出在 redis 中的保留时间，没有配置时保留 10 分钟
+func (_self *OutputMgr) ttl() time.Duration {
+	if common.GConfig.Worker.OutputTTL <= 0 {
+		return 600 * time.Second
+	}
+	return time.Duration(common.GConfig.Worker.OutputTTL) * time.Second
+}
+
+// 输出在 redis 中最多保留的片段数，没有配置时保留 1000 个
+func (_self *OutputMgr) tailChunks() int64 {
+	if common.GConfig.Worker.OutputTailChunks <= 0 {
+		return 1000
+	}
+	return int64(common.GConfig.Worker.OutputTailChunks)
+}
+
+// 输出推送协程
+func (_self *OutputMgr) pushLoop() {
+	var (
+		err        error
+		event      *outputEvent
+		chunkValue []byte
+		listKey    string
+		seqMap     = make(map[string]int64) // 每个任务本轮执行的下一个片段序号
+	)
+
+	for event = range _self.eventChan {
+		listKey = common.JobOutputDir + event.jobName
+
+		switch event.typ {
+		case outputEventBegin:
+			seqMap[event.jobName] = 0
+			if err = common.GRdb.RDB.Del(context.TODO(), listKey).Err(); err == nil {
+				err = _self.setState(event.jobName, common.JobOutputRunning, event.startTime)
+			}
+		case outputEventData:
+			// 片段带上序号，只保留最近的片段，master 按序号续读
+			event.chunk.Seq = seqMap[event.jobName]
+			seqMap[event.jobName]++
+			if chunkValue, err = json.Marshal(event.chunk); err != nil {
+				break
+			}
+			_, err = common.GRdb.RDB.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+				pipe.RPush(context.TODO(), listKey, chunkValue)
+				pipe.LTrim(context.TODO(), listKey, -_self.tailChunks(), -1)
+				pipe.Expire(context.TODO(), listKey, _self.ttl())
+				return nil
+			})
+		case outputEventEnd:
+			delete(seqMap, event.jobName)
+			err = _self.setState(event.jobName, common.JobOutputDone, event.startTime)
+		}
+
+		if err != nil {
+			logger.Warn.Printf("推送任务实时输出失败: %s ", err)
+		}
+	}
+}
+
+// InitOutputMgr 初始化实时输出管理器
+func InitOutputMgr() (err error) {
+	GOutputMgr = &OutputMgr{
+		eventChan: make(chan *outputEvent, 2000),
+	}
+
+	go GOutputMgr.pushLoop()
+	return
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package core
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessGroup 让任务进程运行在独立的进程组中，任务派生的子进程也属于该进程组
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup 向任务的整个进程组发送信号
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// processMaxRSS 进程(包括已回收的子进程)的最大常驻内存(KB)
+func processMaxRSS(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// macOS 上 ru_maxrss 的单位是字节，linux 上是 KB
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss) / 1024
+	}
+	return int64(rusage.Maxrss)
+}
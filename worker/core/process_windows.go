@@ -0,0 +1,23 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup 让任务进程运行在独立的进程组中
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalProcessGroup windows 不支持信号，直接结束整个进程树
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// processMaxRSS windows 的进程状态中没有内存使用信息
+func processMaxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"crontab/worker/common"
+	"crontab/worker/model"
+)
+
+var (
+	GReporter Reporter
+	// 上报模式下 mysql 日志存储由 master 写入，为 true 时执行日志也需要上报
+	reportLogs bool
+)
+
+// Reporter 把 spool 中的任务状态、执行事件和执行日志上报给 master，由 master 写入数据库；
+// 重放失败时同一批记录可能被再次上报，master 按记录的 key 去重
+type Reporter interface {
+	Name() string                               // 上报方式，与配置中的名字一致
+	Report(records []*common.SpoolRecord) error // 按顺序上报一批记录
+}
+
+// etcd 单个请求默认上限为 1.5 MiB，上报的记录不能超过该大小
+const etcdReportMaxBytes = 1024 * 1024
+
+// etcdReporter 每条记录写入 /cron/reports/<key>，master 监听该目录并写入数据库
+type etcdReporter struct {
+	kv      clientv3.KV
+	timeout time.Duration
+}
+
+// 编码一条记录，执行日志超过大小上限时把完整输出保存到文件存储，只上报输出的头部和尾部
+func (_self *etcdReporter) encode(record *common.SpoolRecord) (value []byte, err error) {
+	var (
+		jobLog     model.Log
+		key        string
+		fieldLimit int
+	)
+
+	if value, err = json.Marshal(record); err != nil || len(value) <= etcdReportMaxBytes || record.Log == nil {
+		return
+	}
+
+	// 复制一份再截断，同一批日志还要写入其他日志存储
+	jobLog = *record.Log
+	if jobLog.OutputFile == "" && jobLog.Output != "" {
+		key = fmt.Sprintf("output/%s/%s.log", jobLog.JobName, jobLog.ExecutionID)
+		if err = common.GStorage.Put(key, strings.NewReader(jobLog.Output), int64(len(jobLog.Output))); err != nil {
+			return
+		}
+		jobLog.OutputFile = key
+	}
+	jobLog.OutputTruncated = true
+
+	// 转义后可能变长，每个输出字段只保留上限的 1/16
+	fieldLimit = etcdReportMaxBytes / 16
+	jobLog.Output = capText(jobLog.Output, fieldLimit)
+	jobLog.Stdout = capText(jobLog.Stdout, fieldLimit)
+	jobLog.Stderr = capText(jobLog.Stderr, fieldLimit)
+	jobLog.Err = capText(jobLog.Err, fieldLimit)
+
+	record = &common.SpoolRecord{Key: record.Key, Typ: record.Typ, Log: &jobLog}
+	if value, err = json.Marshal(record); err != nil {
+		return
+	}
+	if len(value) > etcdReportMaxBytes {
+		err = common.ErrReportTooLarge
+	}
+	return
+}
+
+// 只保留文本的头部和尾部
+func capText(text string, limit int) string {
+	var (
+		buf *cappedBuffer
+	)
+
+	if len(text) <= limit {
+		return text
+	}
+	buf = &cappedBuffer{limit: limit}
+	buf.Write([]byte(text))
+	return string(buf.Bytes())
+}
+
+func (_self *etcdReporter) Name() string {
+	return "etcd"
+}
+
+func (_self *etcdReporter) Report(records []*common.SpoolRecord) (err error) {
+	var (
+		value []byte
+	)
+
+	// 逐条写入，master 按写入顺序处理
+	for _, record := range records {
+		if value, err = _self.encode(record); err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.TODO(), _self.timeout)
+		_, err = _self.kv.Put(ctx, common.JobReportDir+record.Key, string(value))
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// masterReporter 调用 master 的 POST /report 接口，一批记录在 master 的一个事务中写入
+type masterReporter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (_self *masterReporter) Name() string {
+	return "master"
+}
+
+func (_self *masterReporter) Report(records []*common.SpoolRecord) (err error) {
+	var (
+		body    []byte
+		req     *http.Request
+		resp    *http.Response
+		content []byte
+		result  struct {
+			Code int         `json:"code"`
+			Msg  interface{} `json:"msg"`
+		}
+	)
+
+	if body, err = json.Marshal(records); err != nil {
+		return
+	}
+	if req, err = http.NewRequest(http.MethodPost, _self.url, bytes.NewReader(body)); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Report-Token", _self.token)
+
+	if resp, err = _self.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if content, err = ioutil.ReadAll(resp.Body); err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("上报失败: %s %s", resp.Status, string(content))
+	}
+	// 接口返回 {"code": 200, "msg": "..."}，code 不是 200 时表示写入失败
+	if err = json.Unmarshal(content, &result); err != nil {
+		return
+	}
+	if result.Code != 200 {
+		return fmt.Errorf("上报失败: %v", result.Msg)
+	}
+	return
+}
+
+// 需要上报的记录，mysql 不是日志存储时不上报执行日志
+func reportRecords(records []*common.SpoolRecord) (reported []*common.SpoolRecord) {
+	for _, record := range records {
+		if record.Typ != common.SpoolTypLog || reportLogs {
+			reported = append(reported, record)
+		}
+	}
+	return
+}
+
+// InitReporter 根据配置初始化上报方式，db 方式(默认)时 worker 直接写数据库
+func InitReporter() (err error) {
+	var (
+		conf    common.ReportConf
+		client  *clientv3.Client
+		timeout time.Duration
+	)
+
+	conf = common.GConfig.Report
+	if timeout = time.Duration(conf.Timeout) * time.Second; timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	switch conf.Mode {
+	case "", "db":
+		GReporter = nil
+	case "etcd":
+		if client, err = clientv3.New(clientv3.Config{
+			Endpoints:   common.GConfig.Etcd.Endpoints,                                // 集群地址
+			DialTimeout: time.Duration(common.GConfig.Etcd.DialTimeout) * time.Second, // 连接超时
+		}); err != nil {
+			return
+		}
+		GReporter = &etcdReporter{kv: clientv3.NewKV(client), timeout: timeout}
+	case "master":
+		GReporter = &masterReporter{
+			url:    strings.TrimSuffix(conf.MasterURL, "/") + "/report",
+			token:  conf.Token,
+			client: &http.Client{Timeout: timeout},
+		}
+	default:
+		err = fmt.Errorf("%s: %s", common.ErrReportModeUnknown, conf.Mode)
+	}
+	return
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"crontab/worker/common"
@@ -18,6 +20,12 @@ type Scheduler struct {
 	jobPlanTable      map[string]*common.JobSchedulePlan // 任务调度计划表
 	jobExecutingTable map[string]*common.JobExecuteInfo  // 任务执行表
 	jobResultChan     chan *common.JobExecuteResult      // 任务结果队列
+	running           int64                              // 执行表中的任务数，供注册信息读取
+}
+
+// RunningCount 正在执行的任务数
+func (_self *Scheduler) RunningCount() int {
+	return int(atomic.LoadInt64(&_self.running))
 }
 
 // PushJobEvent 推送任务变化事件
@@ -33,36 +41,47 @@ func (_self *Scheduler) handleJobEvent(jobEvent *common.JobEvent) {
 		jobExisted      bool
 		jobSchedulePlan *common.JobSchedulePlan
 		jobExecuteInfo  *common.JobExecuteInfo
+		selector        common.Selector
 	)
 
 	switch jobEvent.EventType {
 	case common.JobEventSave: // 保存任务事件
+		// 节点标签不满足任务的选择器时不调度，也不参与抢锁；任务修改后不再匹配时从计划表中移除
+		if selector, err = common.ParseSelector(jobEvent.Job.Selector); err != nil {
+			logger.Error.With("job", jobEvent.Job.Name).Printf("解析节点选择器失败: %s ", err)
+			return
+		}
+		if !selector.Matches(common.GConfig.Worker.Labels) {
+			delete(_self.jobPlanTable, jobEvent.Job.Name)
+			logger.Debug.With("job", jobEvent.Job.Name, "selector", jobEvent.Job.Selector).Println("节点标签不满足任务的选择器，不调度")
+			return
+		}
 		if jobSchedulePlan, err = common.BuildJobSchedulePlan(jobEvent.Job); err != nil {
-			logger.Error.Printf("构造调度任务失败: ", err)
+			logger.Error.With("job", jobEvent.Job.Name).Printf("构造调度任务失败: %s ", err)
 			return
 		}
 		_self.jobPlanTable[jobEvent.Job.Name] = jobSchedulePlan
 		GStatusMgr.pushStatusEvent(common.BuildStatusEvent(common.StatusTyp["待执行"], jobEvent.Job, jobSchedulePlan.NextTime, false), jobEvent.Job.Typ)
-		logger.Info.Println(jobEvent.Job.Name, ": 已同步至任务调度表！")
+		logger.Info.With("job", jobEvent.Job.Name).Println("已同步至任务调度表！")
 
 	case common.JobEventDelete: // 删除任务事件
 		if jobSchedulePlan, jobExisted = _self.jobPlanTable[jobEvent.Job.Name]; jobExisted {
 			delete(_self.jobPlanTable, jobEvent.Job.Name)
 			GStatusMgr.pushStatusEvent(common.BuildStatusEvent(common.StatusTyp["已删除"], jobEvent.Job, jobSchedulePlan.NextTime, false), jobEvent.Job.Typ)
-			logger.Info.Println(jobEvent.Job.Name, ": 任务删除成功！")
+			logger.Info.With("job", jobEvent.Job.Name).Println("任务删除成功！")
 			return
 		}
-		logger.Info.Println(jobEvent.Job.Name, ": 任务不存在，删除失败！")
+		logger.Info.With("job", jobEvent.Job.Name).Println("任务不存在，删除失败！")
 
 	case common.JobEventKill: // 强杀任务事件
 		// 取消掉 Command 执行, 判断任务是否在执行中
 		if jobExecuteInfo, jobExecuting = _self.jobExecutingTable[jobEvent.Job.Name]; jobExecuting {
 			jobExecuteInfo.CancelFunc() // 触发command杀死shell子进程, 任务得到退出
 			GStatusMgr.pushStatusEvent(common.BuildStatusEvent(common.StatusTyp["执行异常"], jobExecuteInfo.Job, jobExecuteInfo.PlanTime, true), jobExecuteInfo.Job.Typ)
-			logger.Info.Println(jobEvent.Job.Name, ": 任务强杀成功！")
+			logger.Info.With("job", jobEvent.Job.Name).Println("任务强杀成功！")
 			return
 		}
-		logger.Info.Println(jobEvent.Job.Name, ": 任务未运行，强杀失败！")
+		logger.Info.With("job", jobEvent.Job.Name).Println("任务未运行，强杀失败！")
 	}
 }
 
@@ -83,7 +102,7 @@ func (_self *Scheduler) TrySchedule() (scheduleAfter time.Duration) {
 		return
 	}
 
-	logger.Info.Printf("调度表共 %s 个任务待调度 ", len(_self.jobPlanTable))
+	logger.Info.Printf("调度表共 %d 个任务待调度 ", len(_self.jobPlanTable))
 	// 当前时间
 	now = time.Now()
 	// 遍历所有任务
@@ -124,18 +143,22 @@ func (_self *Scheduler) TryStartJob(jobPlan *common.JobSchedulePlan) {
 
 	// 如果任务正在执行，跳过本次执行
 	if jobExecuteInfo, jobExecuting = _self.jobExecutingTable[jobPlan.Job.Name]; jobExecuting {
-		logger.Info.Println(jobPlan.Job.Name, ": 尚未退出，取消本次执行。下次执行时间：", jobPlan.Expr.Next(time.Now()))
+		jobLogger(jobExecuteInfo).Info.Println("尚未退出，取消本次执行。下次执行时间：", jobPlan.Expr.Next(time.Now()))
+		GStatusMgr.pushExecutionEvent(jobExecuteInfo, common.StatusTyp["执行中"], common.EventReason["跳过执行"],
+			fmt.Sprintf("上一次执行尚未结束，跳过计划时间为 %s 的执行", jobPlan.NextTime.Format("2006/01/02 15:04:05")))
 		return
 	}
 
 	// 将成功执行的任务放入任务执行列表中
 	jobExecuteInfo = common.BuildJobExecuteInfo(jobPlan)
 	_self.jobExecutingTable[jobPlan.Job.Name] = jobExecuteInfo
+	atomic.StoreInt64(&_self.running, int64(len(_self.jobExecutingTable)))
 
 	// 执行任务
 	GExecutor.ExecuteJob(jobExecuteInfo)
 	GStatusMgr.pushStatusEvent(common.BuildStatusEvent(common.StatusTyp["执行中"], jobPlan.Job, jobPlan.NextTime, false), jobPlan.Job.Typ)
-	logger.Info.Println(jobPlan.Job.Name, ": 任务执行中！")
+	GStatusMgr.pushExecutionEvent(jobExecuteInfo, common.StatusTyp["执行中"], common.EventReason["开始执行"], "")
+	jobLogger(jobExecuteInfo).Info.Println("任务执行中！")
 }
 
 // PushJobResult 回传任务执行结果
@@ -150,41 +173,80 @@ func (_self *Scheduler) handleJobResult(result *common.JobExecuteResult) {
 		err       error
 		job       model.Job
 		jobLog    *model.Log
+		log       *logger.Entry
+		reason    string
 	)
 
+	log = jobLogger(result.ExecuteInfo)
+
 	// 从执行表中删除
 	delete(_self.jobExecutingTable, result.ExecuteInfo.Job.Name)
+	atomic.StoreInt64(&_self.running, int64(len(_self.jobExecutingTable)))
 	// 单次任务还要从计划表中删除，避免被再次调度到执行表
 	if result.ExecuteInfo.Job.Typ == 1 {
 		delete(_self.jobPlanTable, result.ExecuteInfo.Job.Name)
 		// 从 etcd 中删除，避免新 worker 上线时会将其同步到计划表中去
 		if _, err = GJobMgr.DeleteJob(result.ExecuteInfo.Job.Name); err != nil {
-			logger.Error.Println(result.ExecuteInfo.Job.Name, ": etcd 中单次任务删除失败！")
+			log.Error.Printf("etcd 中单次任务删除失败: %s ", err)
 		}
 	}
 
-	if err = common.GMsql.DB.Where("name=?", result.ExecuteInfo.Job.Name).First(&job).Error; err != nil {
-		logger.Error.Println("查询到日对应的任务失败: ", err)
+	// 上报模式下 worker 没有连接数据库，任务 id 由 master 写入日志时补充
+	if common.GMsql != nil {
+		if err = common.GMsql.DB.Where("name=?", result.ExecuteInfo.Job.Name).First(&job).Error; err != nil {
+			log.Error.Printf("查询日志对应的任务失败: %s ", err)
+		}
 	}
+	// 任务正在其他 worker 上执行，本次执行没有开始
+	if result.Err == common.ErrLockAlreadyRequired {
+		GStatusMgr.pushExecutionEvent(result.ExecuteInfo, common.StatusTyp["待执行"], common.EventReason["抢锁失败"], result.Err.Error())
+	}
+
 	// 生成执行日志
 	if result.Err != common.ErrLockAlreadyRequired {
 		jobLog = &model.Log{
-			JobName:      result.ExecuteInfo.Job.Name,
-			Command:      result.ExecuteInfo.Job.Command,
-			Output:       string(result.Output),
-			PlanTime:     result.ExecuteInfo.PlanTime.Format("2006/01/02 15:04:05"),
-			ScheduleTime: result.ExecuteInfo.RealTime.Format("2006/01/02 15:04:05"),
-			StartTime:    result.StartTime.Format("2006/01/02 15:04:05"),
-			EndTime:      result.EndTime.Format("2006/01/02 15:04:05"),
-			JobID:        int(job.ID),
+			JobName:         result.ExecuteInfo.Job.Name,
+			ExecutionID:     result.ExecuteInfo.ExecutionID,
+			Worker:          GRegister.localIP,
+			WorkerID:        GRegister.workerID,
+			Command:         result.Command,
+			Output:          string(result.Output),
+			Stdout:          string(result.Stdout),
+			Stderr:          string(result.Stderr),
+			ExitCode:        result.ExitCode,
+			Signal:          result.Signal,
+			OutputSize:      result.OutputSize,
+			OutputTruncated: result.OutputTruncated,
+			OutputFile:      result.OutputFile,
+			Artifacts:       common.PackArtifacts(result.Artifacts),
+			PlanTime:        result.ExecuteInfo.PlanTime,
+			ScheduleTime:    result.ExecuteInfo.RealTime,
+			StartTime:       result.StartTime,
+			EndTime:         result.EndTime,
+			LockLost:        result.LockLost,
+			DurationMs:      result.EndTime.Sub(result.StartTime).Milliseconds(),
+			UserCPUMs:       result.UserCPU.Milliseconds(),
+			SysCPUMs:        result.SysCPU.Milliseconds(),
+			MaxRSSKB:        result.MaxRSS,
+			JobID:           int(job.ID),
 		}
 
-		if result.Err != nil {
-			jobLog.Err = result.Err.Error()
+		if result.LockLost {
+			jobLog.LockLostTime = &result.LockLostTime
+			log.Warn.Println("任务执行过程中锁丢失！")
+		}
+
+		// 按任务的成功条件判断执行结果
+		if err = common.CheckJobResult(result.ExecuteInfo.Job, result); err != nil {
+			jobLog.Err = err.Error()
 			jobLog.Result = "0"
 			statusTyp = common.StatusTyp["执行异常"]
 			// TODO 发送邮件
-			logger.Error.Println(result.ExecuteInfo.Job.Name, ": 任务执行异常！")
+			log.Error.With("err", jobLog.Err).Println("任务执行异常！")
+			reason = result.Reason
+			if reason == "" {
+				reason = common.EventReason["执行失败"]
+			}
 		} else {
 			jobLog.Err = ""
 			jobLog.Result = "1"
@@ -195,8 +257,10 @@ func (_self *Scheduler) handleJobResult(result *common.JobExecuteResult) {
 				// 单次任务
 				statusTyp = common.StatusTyp["已完成"]
 			}
-			logger.Info.Println(result.ExecuteInfo.Job.Name, ": 任务执行成功！")
+			log.Info.With("duration_ms", jobLog.DurationMs).Println("任务执行成功！")
+			reason = common.EventReason["执行成功"]
 		}
+		GStatusMgr.pushExecutionEvent(result.ExecuteInfo, statusTyp, reason, jobLog.Err)
 		GStatusMgr.pushStatusEvent(common.BuildStatusEvent(statusTyp, result.ExecuteInfo.Job, result.ExecuteInfo.PlanTime, true), result.ExecuteInfo.Job.Typ)
 		GLogMgr.Append(jobLog)
 	}
@@ -0,0 +1,460 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"crontab/worker/common"
+	"crontab/worker/logger"
+	"crontab/worker/model"
+)
+
+var (
+	GSpool *Spool
+)
+
+// spool 文件后缀
+const spoolSuffix = ".jsonl"
+
+// 任务状态和执行事件写入后最多等待该时间再交给重放协程
+const spoolStatusDelay = time.Second
+
+// Spool 日志和任务状态的本地预写 spool。
+// 每次写入先追加到当前 spool 文件并落盘，文件写满 log_batch_size 条、超过 log_commit_timeout 秒或者写入任务状态约 1 秒后关闭，
+// 由重放协程按顺序写入数据库和日志存储，写入成功后删除；数据库不可用时保留在磁盘上并定时重试。
+// 每条记录带有唯一 key，写入数据库时同时记录 key，重放中断后再次重放不会重复写入
+type Spool struct {
+	records    int64 // 没有写入数据库的记录数，放在第一个字段保证原子操作时 64 位对齐
+	dir        string
+	lock       sync.Mutex
+	active     *os.File      // 当前写入的文件
+	activeSeq  int64         // 当前文件序号
+	activeNum  int           // 当前文件的记录数
+	activeTime time.Time     // 当前文件创建时间
+	statusTime time.Time     // 当前文件中第一条任务状态或执行事件的写入时间，为零值时表示没有
+	nextSeq    int64         // 下一个文件序号
+	retryMap   map[int64]int // 每个文件的重试次数
+}
+
+// Write 写入一条记录
+func (_self *Spool) Write(record *common.SpoolRecord) (err error) {
+	var (
+		line []byte
+	)
+
+	if record.Key == "" {
+		record.Key = uuid.New().String()
+	}
+	if line, err = json.Marshal(record); err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	if _self.active == nil {
+		if err = _self.openActive(); err != nil {
+			return
+		}
+	}
+	if _, err = _self.active.Write(line); err != nil {
+		return
+	}
+	if err = _self.active.Sync(); err != nil {
+		return
+	}
+	atomic.AddInt64(&_self.records, 1)
+
+	// 任务状态和执行事件需要尽快更新，由重放协程在 spoolStatusDelay 后关闭文件，期间的记录仍然在同一批中写入
+	if record.Typ != common.SpoolTypLog && _self.statusTime.IsZero() {
+		_self.statusTime = time.Now()
+	}
+
+	// 写满一个批次后关闭，交给重放协程写入数据库
+	if _self.activeNum++; _self.activeNum >= common.GConfig.Worker.LogBatchSize {
+		err = _self.closeActive()
+	}
+	return
+}
+
+// 创建新的 spool 文件，调用方需要持有锁
+func (_self *Spool) openActive() (err error) {
+	_self.activeSeq = _self.nextSeq
+	if _self.active, err = os.OpenFile(_self.segmentPath(_self.activeSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		_self.active = nil
+		return
+	}
+	_self.nextSeq++
+	_self.activeNum = 0
+	_self.activeTime = time.Now()
+	_self.statusTime = time.Time{}
+	return
+}
+
+// 关闭当前 spool 文件，调用方需要持有锁
+func (_self *Spool) closeActive() (err error) {
+	if _self.active == nil {
+		return
+	}
+	err = _self.active.Close()
+	_self.active = nil
+	return
+}
+
+// 超时没有写满的文件也关闭，避免日志长时间不写入数据库；有任务状态或执行事件的文件在 spoolStatusDelay 后关闭
+func (_self *Spool) rotateTimeout() {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	if _self.active == nil {
+		return
+	}
+	if time.Since(_self.activeTime) >= time.Duration(common.GConfig.Worker.LogCommitTimeout)*time.Second ||
+		(!_self.statusTime.IsZero() && time.Since(_self.statusTime) >= spoolStatusDelay) {
+		if err := _self.closeActive(); err != nil {
+			logger.Error.Printf("关闭 spool 文件失败: %s ", err)
+		}
+	}
+}
+
+// spool 文件路径
+func (_self *Spool) segmentPath(seq int64) string {
+	return filepath.Join(_self.dir, fmt.Sprintf("%020d%s", seq, spoolSuffix))
+}
+
+// 所有 spool 文件的序号，升序
+func (_self *Spool) segments() (seqs []int64, err error) {
+	var (
+		infos []os.FileInfo
+		seq   int64
+	)
+
+	if infos, err = ioutil.ReadDir(_self.dir); err != nil {
+		return
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), spoolSuffix) {
+			continue
+		}
+		if seq, err = strconv.ParseInt(strings.TrimSuffix(info.Name(), spoolSuffix), 10, 64); err != nil {
+			err = nil
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return
+}
+
+// 已经关闭、等待写入数据库的 spool 文件
+func (_self *Spool) closedSegments() (seqs []int64, err error) {
+	var (
+		allSeqs []int64
+	)
+
+	if allSeqs, err = _self.segments(); err != nil {
+		return
+	}
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+	for _, seq := range allSeqs {
+		if _self.active != nil && seq == _self.activeSeq {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	return
+}
+
+// 读取 spool 文件中的记录，进程崩溃时最后一行可能不完整，跳过无法解析的行
+func readSegment(path string) (records []*common.SpoolRecord, err error) {
+	var (
+		file    *os.File
+		scanner *bufio.Scanner
+	)
+
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner = bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		record := &common.SpoolRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			logger.Warn.Printf("跳过无法解析的 spool 记录: %s: %s ", path, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	err = scanner.Err()
+	return
+}
+
+// 在一个事务中把任务状态和执行事件写入数据库，已经写入过的记录跳过
+func applyStatuses(records []*common.SpoolRecord) error {
+	return common.GMsql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		var (
+			result *gorm.DB
+		)
+
+		for _, record := range records {
+			if record.Typ == common.SpoolTypLog {
+				continue
+			}
+			// key 写入成功说明记录没有被写入过
+			if result = tx.Clauses(clause.Insert{Modifier: "IGNORE"}).Create(&model.SpoolKey{Key: record.Key}); result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			if record.Typ == common.SpoolTypEvent {
+				err = tx.Create(record.Event).Error
+			} else {
+				err = applyStatus(tx, record.Status)
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// 标记文件已经写入某个存储的文件，重试时跳过已经写入成功的存储
+func (_self *Spool) donePath(seq int64, name string) string {
+	return _self.segmentPath(seq) + "." + name + ".done"
+}
+
+// 按顺序写入一个存储，写入成功后创建标记文件
+func (_self *Spool) applyOnce(seq int64, name string, apply func() error) (err error) {
+	if _, err = os.Stat(_self.donePath(seq, name)); err == nil {
+		return
+	}
+	if err = apply(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return ioutil.WriteFile(_self.donePath(seq, name), nil, 0644)
+}
+
+// 删除文件的所有标记文件
+func (_self *Spool) removeDone(seq int64) {
+	var (
+		matches []string
+	)
+
+	matches, _ = filepath.Glob(_self.segmentPath(seq) + ".*.done")
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
+
+// 重放一个 spool 文件：先更新任务状态和执行事件(上报模式下上报给 master)，再把日志写入所有日志存储，全部成功后删除
+func (_self *Spool) replaySegment(seq int64) (err error) {
+	var (
+		path    string
+		records []*common.SpoolRecord
+		logs    []*model.Log
+	)
+
+	path = _self.segmentPath(seq)
+	if records, err = readSegment(path); err != nil {
+		return
+	}
+	for _, record := range records {
+		if record.Typ == common.SpoolTypLog {
+			logs = append(logs, record.Log)
+		}
+	}
+
+	if GReporter != nil {
+		if reported := reportRecords(records); len(reported) > 0 {
+			if err = _self.applyOnce(seq, GReporter.Name(), func() error { return GReporter.Report(reported) }); err != nil {
+				return
+			}
+		}
+	} else if len(records) > len(logs) {
+		if err = _self.applyOnce(seq, "status", func() error { return applyStatuses(records) }); err != nil {
+			return
+		}
+	}
+	if len(logs) > 0 {
+		for _, sink := range GLogSinks {
+			if err = _self.applyOnce(seq, sink.Name(), func() error { return sink.WriteLogs(logs) }); err != nil {
+				return
+			}
+		}
+	}
+
+	if err = os.Remove(path); err != nil {
+		return
+	}
+	_self.removeDone(seq)
+	atomic.AddInt64(&_self.records, -int64(len(records)))
+	return
+}
+
+// 写入失败次数过多的文件移入 failed 目录，避免一直阻塞后续的记录
+func (_self *Spool) moveFailed(seq int64) (err error) {
+	var (
+		records []*common.SpoolRecord
+	)
+
+	if records, err = readSegment(_self.segmentPath(seq)); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Join(_self.dir, "failed"), 0755); err != nil {
+		return
+	}
+	if err = os.Rename(_self.segmentPath(seq), filepath.Join(_self.dir, "failed", filepath.Base(_self.segmentPath(seq)))); err != nil {
+		return
+	}
+	_self.removeDone(seq)
+	atomic.AddInt64(&_self.records, -int64(len(records)))
+	return
+}
+
+// 重放协程
+func (_self *Spool) replayLoop() {
+	var (
+		err   error
+		seqs  []int64
+		retry time.Duration
+	)
+
+	retry = time.Duration(common.GConfig.Worker.SpoolRetry) * time.Second
+	if retry <= 0 {
+		retry = 5 * time.Second
+	}
+
+	for {
+		_self.rotateTimeout()
+
+		if seqs, err = _self.closedSegments(); err != nil {
+			logger.Error.Printf("读取 spool 目录失败: %s ", err)
+		}
+
+		// 按顺序写入，前面的文件写入失败时等待重试，保证任务状态的顺序
+		err = nil
+		for _, seq := range seqs {
+			if err = _self.replaySegment(seq); err == nil {
+				delete(_self.retryMap, seq)
+				continue
+			}
+			logger.Error.Printf("spool 写入数据库失败，%s 后重试: %s ", retry, err)
+
+			_self.retryMap[seq]++
+			// 记录过大时重试也不会成功，直接移入 failed 目录
+			if errors.Is(err, common.ErrReportTooLarge) ||
+				(common.GConfig.Worker.SpoolMaxRetry > 0 && _self.retryMap[seq] >= common.GConfig.Worker.SpoolMaxRetry) {
+				logger.Error.Printf("spool 文件 %d 重试 %d 次仍然失败，移入 failed 目录 ", seq, _self.retryMap[seq])
+				if err = _self.moveFailed(seq); err != nil {
+					logger.Error.Printf("移动 spool 文件失败: %s ", err)
+				}
+				delete(_self.retryMap, seq)
+			}
+			break
+		}
+
+		if err != nil {
+			time.Sleep(retry)
+		} else {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// purgeKeysLoop 定时清理已经过期的幂等 key
+func (_self *Spool) purgeKeysLoop() {
+	var (
+		err error
+	)
+
+	for {
+		if err = common.GMsql.DB.Where("created_at < ?", time.Now().AddDate(0, 0, -7)).Delete(&model.SpoolKey{}).Error; err != nil {
+			logger.Warn.Printf("清理 spool key 失败: %s ", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// Size 没有写入数据库的记录数、文件数和字节数
+func (_self *Spool) Size() (records int64, segments int, bytes int64) {
+	var (
+		seqs []int64
+		info os.FileInfo
+		err  error
+	)
+
+	records = atomic.LoadInt64(&_self.records)
+	if seqs, err = _self.segments(); err != nil {
+		return
+	}
+	for _, seq := range seqs {
+		if info, err = os.Stat(_self.segmentPath(seq)); err == nil {
+			segments++
+			bytes += info.Size()
+		}
+	}
+	return
+}
+
+// InitSpool 初始化 spool，上次退出时没有写入数据库的记录会被重放
+func InitSpool() (err error) {
+	var (
+		seqs    []int64
+		records []*common.SpoolRecord
+	)
+
+	GSpool = &Spool{
+		dir:      common.GConfig.Worker.SpoolDir,
+		retryMap: make(map[int64]int),
+	}
+	if GSpool.dir == "" {
+		GSpool.dir = "spool"
+	}
+	if err = os.MkdirAll(GSpool.dir, 0755); err != nil {
+		return
+	}
+
+	// 统计遗留的记录，新文件的序号接在遗留文件之后
+	if seqs, err = GSpool.segments(); err != nil {
+		return
+	}
+	for _, seq := range seqs {
+		if records, err = readSegment(GSpool.segmentPath(seq)); err != nil {
+			return
+		}
+		GSpool.records += int64(len(records))
+		GSpool.nextSeq = seq + 1
+	}
+	if len(seqs) > 0 {
+		logger.Info.Printf("spool 中有 %d 条记录等待写入数据库 ", GSpool.records)
+	}
+
+	go GSpool.replayLoop()
+	// 上报模式下幂等 key 由 master 清理
+	if GReporter == nil {
+		go GSpool.purgeKeysLoop()
+	}
+	return
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 
 	"crontab/worker/common"
@@ -12,59 +14,74 @@ var (
 	GStatusMgr *StatusMgr
 )
 
+// StatusMgr 任务状态管理器，状态先写入本地 spool，再由 spool 写入数据库
 type StatusMgr struct {
-	crontabChan chan *common.JobStatusEvent
-	OnceChan    chan *common.JobStatusEvent
 }
 
 func (_self *StatusMgr) pushStatusEvent(eve *common.JobStatusEvent, jobType int) {
-	if jobType == 0 {
-		// 定时任务
-		_self.crontabChan <- eve
-	} else {
-		// 单次任务
-		_self.OnceChan <- eve
+	var (
+		err error
+	)
+
+	if err = GSpool.Write(&common.SpoolRecord{
+		Typ: common.SpoolTypStatus,
+		Status: &common.SpoolStatus{
+			JobName:   eve.Job.Name,
+			JobTyp:    jobType,
+			StatusTyp: eve.StatusTyp,
+			AddNum:    eve.AddNum,
+			NextTime:  eve.NextTime,
+		},
+	}); err != nil {
+		logger.Error.Printf("任务状态写入 spool 失败: %s ", err)
 	}
 }
 
-func (_self *StatusMgr) updateStatusLoop() {
-
+// pushExecutionEvent 记录一次执行的状态变化
+func (_self *StatusMgr) pushExecutionEvent(info *common.JobExecuteInfo, statusTyp int, reason string, message string) {
 	var (
-		err         error
-		statusEvent *common.JobStatusEvent
+		err error
 	)
-	for {
-		select {
-		case statusEvent = <-_self.crontabChan:
-			if err = common.GMsql.DB.Model(&model.Job{}).Where("name = ?", statusEvent.Job.Name).
-				Updates(map[string]interface{}{
-					"status":    statusEvent.StatusTyp,
-					"next_time": statusEvent.NextTime,
-					"num":       gorm.Expr("num + ?", common.GetNumField(statusEvent.AddNum)), // 对执行过的任务的执行次数进行加 1
-				}).Error; err != nil {
-				logger.Error.Printf("同步任务状态失败: ", err)
-			}
 
-		case statusEvent = <-_self.OnceChan:
-			if err = common.GMsql.DB.Model(&model.Job{}).Where("name = ?", statusEvent.Job.Name).
-				Updates(map[string]interface{}{
-					"num":       1,
-					"status":    statusEvent.StatusTyp,
-					"next_time": statusEvent.NextTime,
-				}).Error; err != nil {
-				logger.Error.Printf("同步任务状态失败: ", err)
-			}
-		}
+	if err = GSpool.Write(&common.SpoolRecord{
+		Typ: common.SpoolTypEvent,
+		Event: &model.ExecutionEvent{
+			ExecutionID: info.ExecutionID,
+			JobName:     info.Job.Name,
+			Worker:      GRegister.localIP,
+			WorkerID:    GRegister.workerID,
+			Status:      statusTyp,
+			Reason:      reason,
+			Message:     message,
+			EventTime:   time.Now(),
+		},
+	}); err != nil {
+		jobLogger(info).Error.Printf("执行事件写入 spool 失败: %s ", err)
 	}
 }
 
-func InitStatusMgr() (err error) {
-
-	GStatusMgr = &StatusMgr{
-		crontabChan: make(chan *common.JobStatusEvent, 1000),
-		OnceChan:    make(chan *common.JobStatusEvent, 1000),
+// applyStatus 把任务状态写入数据库
+func applyStatus(tx *gorm.DB, status *common.SpoolStatus) error {
+	if status.JobTyp == 0 {
+		// 定时任务
+		return tx.Model(&model.Job{}).Where("name = ?", status.JobName).
+			Updates(map[string]interface{}{
+				"status":    status.StatusTyp,
+				"next_time": status.NextTime,
+				"num":       gorm.Expr("num + ?", common.GetNumField(status.AddNum)), // 对执行过的任务的执行次数进行加 1
+			}).Error
 	}
 
-	go GStatusMgr.updateStatusLoop()
+	// 单次任务
+	return tx.Model(&model.Job{}).Where("name = ?", status.JobName).
+		Updates(map[string]interface{}{
+			"num":       1,
+			"status":    status.StatusTyp,
+			"next_time": status.NextTime,
+		}).Error
+}
+
+func InitStatusMgr() (err error) {
+	GStatusMgr = &StatusMgr{}
 	return
 }
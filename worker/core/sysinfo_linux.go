@@ -0,0 +1,17 @@
+package core
+
+import (
+	"syscall"
+)
+
+// 本机物理内存(字节)
+func totalMemory() uint64 {
+	var (
+		info syscall.Sysinfo_t
+	)
+
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0
+	}
+	return uint64(info.Totalram) * uint64(info.Unit)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+// 本机物理内存(字节)，非 linux 平台不统计
+func totalMemory() uint64 {
+	return 0
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"crontab/worker/common"
+)
+
+// TaskFunc 内置任务函数
+// params 为任务配置的 JSON 参数，ctx 在任务被强杀或执行超时时取消；返回的 output 作为任务的标准输出记录到日志中
+type TaskFunc func(ctx context.Context, params json.RawMessage) (output []byte, err error)
+
+var (
+	taskRegistry = make(map[string]TaskFunc) // 已注册的内置任务
+	taskLock     sync.RWMutex
+)
+
+// taskSecretsKey 任务引用的密钥在 ctx 中的 key
+type taskSecretsKey struct{}
+
+// TaskSecret 在内置任务中读取任务引用的密钥
+func TaskSecret(ctx context.Context, name string) (value string, ok bool) {
+	var (
+		secrets map[string]string
+	)
+
+	if secrets, ok = ctx.Value(taskSecretsKey{}).(map[string]string); ok {
+		value, ok = secrets[name]
+	}
+	return
+}
+
+// RegisterTask 注册内置任务，一般在包的 init 函数中调用；任务名重复时 panic
+func RegisterTask(name string, fn TaskFunc) {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+
+	if name == "" || fn == nil {
+		panic("内置任务名和任务函数不能为空")
+	}
+	if _, existed := taskRegistry[name]; existed {
+		panic(fmt.Sprintf("内置任务重复注册: %s", name))
+	}
+	taskRegistry[name] = fn
+}
+
+// LookupTask 查找内置任务
+func LookupTask(name string) (fn TaskFunc, err error) {
+	var (
+		existed bool
+	)
+
+	taskLock.RLock()
+	defer taskLock.RUnlock()
+
+	if fn, existed = taskRegistry[name]; !existed {
+		err = common.ErrTaskUnknown
+	}
+	return
+}
+
+// callTask 调用任务函数，任务 panic 时转换为错误，避免影响 worker
+func callTask(ctx context.Context, fn TaskFunc, params json.RawMessage) (output []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("内置任务 panic: %v", r)
+		}
+	}()
+	return fn(ctx, params)
+}
@@ -2,6 +2,9 @@ package core
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"runtime"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -21,6 +24,42 @@ type Register struct {
 	lease        clientv3.Lease
 	registerTime time.Time
 	localIP      string // 本机IP
+	workerID     string // worker 标识
+	hostname     string // 主机名
+}
+
+// 当前的节点信息
+func (_self *Register) workerInfo() (info *common.WorkerInfo) {
+	info = &common.WorkerInfo{
+		IP:         _self.localIP,
+		WorkerID:   _self.workerID,
+		Hostname:   _self.hostname,
+		Version:    common.Version,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUs:       runtime.NumCPU(),
+		Memory:     totalMemory(),
+		Labels:     common.GConfig.Worker.Labels,
+		StartTime:  _self.registerTime,
+		ActiveTime: time.Now(),
+	}
+	if GScheduler != nil {
+		info.Running = GScheduler.RunningCount()
+	}
+	return
+}
+
+// 写入节点信息，绑定到租约
+func (_self *Register) putWorkerInfo(ctx context.Context, regKey string, leaseID clientv3.LeaseID) (err error) {
+	var (
+		value []byte
+	)
+
+	if value, err = json.Marshal(_self.workerInfo()); err != nil {
+		return
+	}
+	_, err = _self.kv.Put(ctx, regKey, string(value), clientv3.WithLease(leaseID))
+	return
 }
 
 // 注册到/cron/workers/IP, 并自动续租
@@ -33,8 +72,15 @@ func (_self *Register) keepOnline() {
 		keepAliveResp  *clientv3.LeaseKeepAliveResponse
 		cancelCtx      context.Context
 		cancelFunc     context.CancelFunc
+		refreshTicker  *time.Ticker
+		interval       time.Duration
 	)
 
+	if interval = time.Duration(common.GConfig.Worker.RegisterInterval) * time.Second; interval <= 0 {
+		interval = 30 * time.Second
+	}
+	refreshTicker = time.NewTicker(interval)
+
 	for {
 		// 注册路径
 		regKey = common.JobWorkerDir + _self.localIP
@@ -54,17 +100,21 @@ func (_self *Register) keepOnline() {
 		cancelCtx, cancelFunc = context.WithCancel(context.TODO())
 
 		// 注册到etcd
-		if _, err = _self.kv.Put(cancelCtx, regKey, time.Now().Format("2006/01/02 15:04:05"), clientv3.WithLease(leaseGrantResp.ID)); err != nil {
+		if err = _self.putWorkerInfo(cancelCtx, regKey, leaseGrantResp.ID); err != nil {
 			goto RETRY
 		}
 
-		// 处理续租应答
+		// 处理续租应答，并定时刷新节点信息
 		for {
 			select {
 			case keepAliveResp = <-keepAliveChan:
 				if keepAliveResp == nil { // 续租失败
 					goto RETRY
 				}
+			case <-refreshTicker.C:
+				if err = _self.putWorkerInfo(cancelCtx, regKey, leaseGrantResp.ID); err != nil {
+					logger.Warn.Printf("刷新节点信息失败: %s ", err)
+				}
 			}
 		}
 
@@ -79,12 +129,14 @@ func (_self *Register) keepOnline() {
 
 func InitRegister() (err error) {
 	var (
-		curTime time.Time
-		config  clientv3.Config
-		client  *clientv3.Client
-		kv      clientv3.KV
-		lease   clientv3.Lease
-		localIp string
+		curTime  time.Time
+		config   clientv3.Config
+		client   *clientv3.Client
+		kv       clientv3.KV
+		lease    clientv3.Lease
+		localIp  string
+		workerID string
+		hostname string
 	)
 
 	// 初始化配置
@@ -109,6 +161,16 @@ func InitRegister() (err error) {
 		logger.Info.Printf("worker 上线， ip：%s, time：%s", localIp, curTime)
 	}
 
+	if hostname, err = os.Hostname(); err != nil {
+		logger.Error.Printf("获取主机名失败: %s", err)
+		return
+	}
+
+	// worker 标识，没有配置时使用 主机名-IP
+	if workerID = common.GConfig.Worker.WorkerID; workerID == "" {
+		workerID = hostname + "-" + localIp
+	}
+
 	// 得到 KV 和 Lease 的 API 子集
 	kv = clientv3.NewKV(client)
 	lease = clientv3.NewLease(client)
@@ -119,6 +181,8 @@ func InitRegister() (err error) {
 		lease:        lease,
 		registerTime: curTime,
 		localIP:      localIp,
+		workerID:     workerID,
+		hostname:     hostname,
 	}
 
 	// 服务注册，并自动续约；当服务宕机，会停止自动续约，一段时间后 key 就自动过期了（worker 下线）
@@ -1,33 +1,258 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// 没有配置日志文件名时使用的文件名
+const defaultFile = "worker.log"
+
+// Level 日志级别，低于当前级别的日志不输出
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (_self Level) String() string {
+	return levelNames[_self]
+}
+
+// ParseLevel 解析日志级别名字，不区分大小写
+func ParseLevel(name string) (level Level, err error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("未知的日志级别: %s", name)
+}
+
+// Config 日志配置
+type Config struct {
+	Format     string `yaml:"format"`      // 输出格式 text 或 json
+	Level      string `yaml:"level"`       // 最低输出级别 debug/info/warn/error
+	Dir        string `yaml:"dir"`         // 日志目录，为空时不写文件
+	File       string `yaml:"file"`        // 日志文件名，为空时使用默认文件名
+	MaxSize    int    `yaml:"max_size"`    // 单个文件的最大大小(MB)，超过后轮转，0 表示不按大小轮转
+	Rotate     string `yaml:"rotate"`      // 按时间轮转 hourly/daily，为空表示不按时间轮转
+	MaxBackups int    `yaml:"max_backups"` // 保留的历史文件数，0 表示不限制
+	MaxAge     int    `yaml:"max_age"`     // 历史文件保留天数，0 表示不限制
+	Stderr     bool   `yaml:"stderr"`      // 是否同时输出到标准错误
+}
+
+// 日志输出的全局状态，Init 之前只输出到标准错误
 var (
-	Debug *log.Logger
-	Info  *log.Logger
-	Warn  *log.Logger
-	Error *log.Logger
+	level            = int32(InfoLevel)
+	format           = "text"
+	out    io.Writer = os.Stderr
+	lock   sync.Mutex
 )
 
-func init() {
-	if _, err := os.Stat("logs"); err != nil {
-		if err = os.Mkdir("logs", 755); err != nil {
-			log.Fatalln("Failed to create logs dir: ", err)
+var (
+	Debug = &Logger{level: DebugLevel}
+	Info  = &Logger{level: InfoLevel}
+	Warn  = &Logger{level: WarnLevel}
+	Error = &Logger{level: ErrorLevel}
+)
+
+// Logger 一个级别的日志输出，可以附带结构化字段
+type Logger struct {
+	level  Level
+	fields []interface{} // 按 key、value 交替保存
+}
+
+func (_self *Logger) Printf(format string, v ...interface{}) {
+	_self.output(fmt.Sprintf(format, v...))
+}
+
+func (_self *Logger) Println(v ...interface{}) {
+	_self.output(fmt.Sprintln(v...))
+}
+
+func (_self *Logger) Print(v ...interface{}) {
+	_self.output(fmt.Sprint(v...))
+}
+
+// With 返回附带了字段的日志，参数按 key、value 交替传入
+func (_self *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(_self.fields)+len(kv))
+	fields = append(append(fields, _self.fields...), kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+	return &Logger{level: _self.level, fields: fields}
+}
+
+// 输出一条日志
+func (_self *Logger) output(msg string) {
+	var (
+		caller string
+		buf    bytes.Buffer
+	)
+
+	if int32(_self.level) < atomic.LoadInt32(&level) {
+		return
+	}
+
+	caller = "???"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	msg = strings.TrimRight(msg, " \n")
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if format == "json" {
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, time.Now().Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, _self.level.String())
+		buf.WriteString(`,"caller":`)
+		writeJSON(&buf, caller)
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for i := 0; i < len(_self.fields); i += 2 {
+			buf.WriteByte(',')
+			writeJSON(&buf, fmt.Sprint(_self.fields[i]))
+			buf.WriteByte(':')
+			writeJSON(&buf, _self.fields[i+1])
 		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %s %s: %s", time.Now().Format("2006/01/02 15:04:05.000"), _self.level, caller, msg)
+		for i := 0; i < len(_self.fields); i += 2 {
+			value := fmt.Sprint(_self.fields[i+1])
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&buf, " %v=%s", _self.fields[i], value)
+		}
+		buf.WriteByte('\n')
+	}
+	out.Write(buf.Bytes())
+}
+
+// 以 JSON 写入一个值，无法序列化时按字符串写入
+func writeJSON(buf *bytes.Buffer, value interface{}) {
+	var (
+		content []byte
+		err     error
+	)
+
+	if valueErr, ok := value.(error); ok {
+		value = valueErr.Error()
+	}
+	if content, err = json.Marshal(value); err != nil {
+		content, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(content)
+}
+
+// Entry 附带了相同字段的一组日志，用法与包级别的日志相同: log.Info.Printf(...)
+type Entry struct {
+	Debug *Logger
+	Info  *Logger
+	Warn  *Logger
+	Error *Logger
+}
+
+// With 返回附带字段的一组日志，参数按 key、value 交替传入，如 logger.With("job", name, "execution_id", id)
+func With(kv ...interface{}) *Entry {
+	return &Entry{
+		Debug: Debug.With(kv...),
+		Info:  Info.With(kv...),
+		Warn:  Warn.With(kv...),
+		Error: Error.With(kv...),
+	}
+}
+
+// With 在已有字段的基础上追加字段
+func (_self *Entry) With(kv ...interface{}) *Entry {
+	return &Entry{
+		Debug: _self.Debug.With(kv...),
+		Info:  _self.Info.With(kv...),
+		Warn:  _self.Warn.With(kv...),
+		Error: _self.Error.With(kv...),
+	}
+}
+
+// SetLevel 运行时修改日志级别
+func SetLevel(name string) (err error) {
+	var (
+		newLevel Level
+	)
+
+	if newLevel, err = ParseLevel(name); err != nil {
+		return
+	}
+	atomic.StoreInt32(&level, int32(newLevel))
+	return
+}
+
+// GetLevel 当前的日志级别
+func GetLevel() string {
+	return Level(atomic.LoadInt32(&level)).String()
+}
+
+// Init 根据配置初始化日志输出，在加载配置之后调用
+func Init(conf Config) (err error) {
+	var (
+		writers []io.Writer
+		file    *rotateWriter
+	)
+
+	if conf.Level != "" {
+		if err = SetLevel(conf.Level); err != nil {
+			return
+		}
+	}
+	switch conf.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("未知的日志格式: %s", conf.Format)
+	}
+	switch conf.Rotate {
+	case "", "hourly", "daily":
+	default:
+		return fmt.Errorf("未知的日志轮转周期: %s", conf.Rotate)
+	}
+
+	if conf.Dir != "" {
+		if conf.File == "" {
+			conf.File = defaultFile
+		}
+		if file, err = newRotateWriter(conf); err != nil {
+			return
+		}
+		writers = append(writers, file)
+	}
+	if conf.Stderr || len(writers) == 0 {
+		writers = append(writers, os.Stderr)
 	}
 
-	// 日志输出文件
-	file, err := os.OpenFile("logs/worker.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalln("Failed to open error logger file:", err)
+	lock.Lock()
+	defer lock.Unlock()
+	if conf.Format != "" {
+		format = conf.Format
 	}
-	//自定义日志格式
-	Debug = log.New(io.MultiWriter(file, os.Stderr), "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Info = log.New(io.MultiWriter(file, os.Stderr), "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Warn = log.New(io.MultiWriter(file, os.Stderr), "WARN: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Error = log.New(io.MultiWriter(file, os.Stderr), "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	out = io.MultiWriter(writers...)
+	return
 }
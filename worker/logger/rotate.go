@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateWriter 日志文件，按大小或时间轮转，轮转后的文件名为 <文件名>-<时间>.<扩展名>
+type rotateWriter struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	rotate     string
+	maxBackups int
+	maxAge     int
+
+	file   *os.File
+	size   int64
+	period string // 当前文件所属的时间周期
+}
+
+func newRotateWriter(conf Config) (writer *rotateWriter, err error) {
+	writer = &rotateWriter{
+		path:       filepath.Join(conf.Dir, conf.File),
+		maxSize:    int64(conf.MaxSize) * 1024 * 1024,
+		rotate:     conf.Rotate,
+		maxBackups: conf.MaxBackups,
+		maxAge:     conf.MaxAge,
+	}
+	if err = os.MkdirAll(conf.Dir, 0755); err != nil {
+		return
+	}
+	err = writer.open()
+	return
+}
+
+// 时间对应的轮转周期，不按时间轮转时为空
+func (_self *rotateWriter) periodOf(t time.Time) string {
+	switch _self.rotate {
+	case "hourly":
+		return t.Format("2006010215")
+	case "daily":
+		return t.Format("20060102")
+	}
+	return ""
+}
+
+// 打开日志文件，已有文件的周期按最后修改时间计算，重启后跨了周期会立即轮转
+func (_self *rotateWriter) open() (err error) {
+	var (
+		info os.FileInfo
+	)
+
+	if _self.file, err = os.OpenFile(_self.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	if info, err = _self.file.Stat(); err != nil {
+		return
+	}
+	_self.size = info.Size()
+	_self.period = _self.periodOf(info.ModTime())
+	if _self.size == 0 {
+		_self.period = _self.periodOf(time.Now())
+	}
+	return
+}
+
+func (_self *rotateWriter) Write(p []byte) (n int, err error) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	if (_self.maxSize > 0 && _self.size > 0 && _self.size+int64(len(p)) > _self.maxSize) ||
+		_self.periodOf(time.Now()) != _self.period {
+		if err = _self.rotateFile(); err != nil {
+			return
+		}
+	}
+
+	n, err = _self.file.Write(p)
+	_self.size += int64(n)
+	return
+}
+
+// 把当前文件改名为历史文件，然后打开新文件
+func (_self *rotateWriter) rotateFile() (err error) {
+	var (
+		ext string
+	)
+
+	_self.file.Close()
+	ext = filepath.Ext(_self.path)
+	if err = os.Rename(_self.path, strings.TrimSuffix(_self.path, ext)+"-"+time.Now().Format("20060102150405.000")+ext); err != nil {
+		return
+	}
+	if err = _self.open(); err != nil {
+		return
+	}
+	go _self.removeBackups()
+	return
+}
+
+// 删除超过保留数量或保留天数的历史文件
+func (_self *rotateWriter) removeBackups() {
+	var (
+		ext     string
+		backups []string
+		info    os.FileInfo
+		err     error
+	)
+
+	ext = filepath.Ext(_self.path)
+	if backups, err = filepath.Glob(strings.TrimSuffix(_self.path, ext) + "-*" + ext); err != nil {
+		return
+	}
+	// 文件名中的时间格式固定，按名字倒序即按时间从新到旧
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, backup := range backups {
+		if _self.maxBackups > 0 && i >= _self.maxBackups {
+			os.Remove(backup)
+			continue
+		}
+		if info, err = os.Stat(backup); err == nil && _self.maxAge > 0 &&
+			time.Since(info.ModTime()) > time.Duration(_self.maxAge)*24*time.Hour {
+			os.Remove(backup)
+		}
+	}
+}
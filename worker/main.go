@@ -38,8 +38,24 @@ func main() {
 		goto ERR
 	}
 
-	// mysql 连接池
-	if err = common.InitMySQLConn(); err != nil {
+	// 服务日志
+	if err = logger.Init(common.GConfig.Log); err != nil {
+		goto ERR
+	}
+
+	// 上报方式，db 方式时连接 mysql 直接写入，其他方式不需要数据库
+	if err = core.InitReporter(); err != nil {
+		goto ERR
+	}
+	if core.GReporter == nil {
+		// mysql 连接池
+		if err = common.InitMySQLConn(); err != nil {
+			goto ERR
+		}
+	}
+
+	// redis 连接池
+	if err = common.InitRedisConn(); err != nil {
 		goto ERR
 	}
 
@@ -48,6 +64,26 @@ func main() {
 	//	goto ERR
 	//}
 
+	// 文件存储
+	if err = common.InitStorage(); err != nil {
+		goto ERR
+	}
+
+	// 执行日志存储
+	if err = core.InitLogSinks(); err != nil {
+		goto ERR
+	}
+
+	// 日志和任务状态的本地 spool
+	if err = core.InitSpool(); err != nil {
+		goto ERR
+	}
+
+	// 启动实时输出管理器
+	if err = core.InitOutputMgr(); err != nil {
+		goto ERR
+	}
+
 	// 服务注册
 	if err = core.InitRegister(); err != nil {
 		goto ERR
@@ -78,6 +114,11 @@ func main() {
 		goto ERR
 	}
 
+	// 启动指标接口
+	if err = core.InitMetrics(); err != nil {
+		goto ERR
+	}
+
 	// 阻塞主协程
 	select {}
 
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// JobMutation 任务变更的发件箱记录，与任务变更在同一个事务中写入，由 master 发布到 etcd
+type JobMutation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	MutationID  string     `gorm:"size:64;uniqueIndex" json:"mutation_id"` // 变更 id，返回给调用方查询发布结果
+	JobName     string     `gorm:"size:64;index" json:"job_name"`          // 任务名字
+	Op          int        `json:"op"`                                     // 变更类型(0: 保存；1: 删除；2: 强杀)
+	Payload     string     `gorm:"type:mediumtext" json:"-"`               // 保存时写入 etcd 的任务 JSON
+	Status      int        `gorm:"index" json:"status"`                    // 发布状态(0: 待发布；1: 已发布；2: 发布失败)
+	Attempts    int        `json:"attempts"`                               // 发布次数
+	LastError   string     `gorm:"type:text" json:"last_error"`            // 最近一次发布失败的原因
+	PublishedAt *time.Time `json:"published_at"`                           // 发布成功的时间
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
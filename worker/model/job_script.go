@@ -0,0 +1,15 @@
+package model
+
+import "gorm.io/gorm"
+
+// JobScript 脚本任务的脚本，每次修改都会保存为一个新版本
+type JobScript struct {
+	gorm.Model
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	JobID       int    `gorm:"uniqueIndex:idx_job_version" json:"job_id"`  // 任务 id
+	JobName     string `gorm:"type:varchar(20);index" json:"job_name"`     // 任务名
+	Version     int    `gorm:"uniqueIndex:idx_job_version" json:"version"` // 脚本版本，从 1 开始递增
+	Interpreter string `gorm:"type:varchar(16)" json:"interpreter"`        // 脚本解释器
+	Script      string `gorm:"type:longtext" json:"script"`                // 脚本内容
+	UserID      int    `json:"user_id"`                                    // 修改人
+}
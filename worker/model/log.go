@@ -1,18 +1,39 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Log struct {
 	gorm.Model
-	ID           uint   `gorm:"primaryKey" json:"id"`
-	JobName      string `json:"job_name"`      // 任务名字
-	Command      string `json:"command"`       // 脚本命令
-	Output       string `json:"output"`        // 命令输出
-	Err          string `json:"err" `          // 错误输出
-	PlanTime     string `json:"plan_time"`     // 计划开始时间
-	ScheduleTime string `json:"schedule_time"` // 实际调度时间
-	StartTime    string `json:"start_time"`    // 任务执行开始时间
-	EndTime      string `json:"end_time"`      // 任务执行结束时间
-	Result       string `json:"result"`        // 任务执行结果，根据是否有错误输出进行标记；0 表示执行出错；1 表示执行成功
-	JobID        int    `json:"job_id"`        // 默认外键，任务 id
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	JobName         string     `gorm:"size:64;index" json:"job_name"`                                            // 任务名字
+	ExecutionID     string     `gorm:"size:64;uniqueIndex:idx_log_execution_id;not null" json:"execution_id"`    // 执行 id，每次执行唯一
+	Worker          string     `gorm:"size:64;index" json:"worker"`                                              // 执行任务的 worker
+	WorkerID        string     `gorm:"size:64;index" json:"worker_id"`                                           // 执行任务的 worker 标识
+	Command         string     `json:"command"`                                                                  // 脚本命令
+	Output          string     `gorm:"index:idx_log_text,class:FULLTEXT,option:WITH PARSER ngram" json:"output"` // 命令输出(stdout 与 stderr 合并)
+	Stdout          string     `json:"stdout"`                                                                   // 标准输出
+	Stderr          string     `json:"stderr"`                                                                   // 标准错误输出
+	ExitCode        int        `json:"exit_code"`                                                                // 进程退出码，进程未正常退出时为 -1
+	Signal          string     `gorm:"size:32" json:"signal"`                                                    // 终止进程的信号
+	Err             string     `gorm:"index:idx_log_text,class:FULLTEXT,option:WITH PARSER ngram" json:"err"`    // 错误输出
+	OutputSize      int64      `json:"output_size"`                                                              // 完整输出的大小(字节)
+	OutputTruncated bool       `json:"output_truncated"`                                                         // 输出是否超过上限被截断
+	OutputFile      string     `gorm:"size:255" json:"output_file"`                                              // 截断时完整输出保存的文件
+	Artifacts       string     `gorm:"type:text" json:"artifacts"`                                               // 任务产物列表(JSON)
+	PlanTime        time.Time  `gorm:"type:datetime(3);not null;index" json:"plan_time"`                         // 计划开始时间
+	ScheduleTime    time.Time  `gorm:"type:datetime(3);not null" json:"schedule_time"`                           // 实际调度时间
+	StartTime       time.Time  `gorm:"type:datetime(3);not null;index" json:"start_time"`                        // 任务执行开始时间
+	EndTime         time.Time  `gorm:"type:datetime(3);not null" json:"end_time"`                                // 任务执行结束时间
+	DurationMs      int64      `gorm:"index" json:"duration_ms"`                                                 // 执行耗时(毫秒)
+	UserCPUMs       int64      `json:"user_cpu_ms"`                                                              // 用户态 CPU 时间(毫秒)
+	SysCPUMs        int64      `json:"sys_cpu_ms"`                                                               // 内核态 CPU 时间(毫秒)
+	MaxRSSKB        int64      `gorm:"column:max_rss_kb" json:"max_rss_kb"`                                      // 最大常驻内存(KB)
+	LockLost        bool       `json:"lock_lost"`                                                                // 执行过程中是否丢失了任务锁
+	LockLostTime    *time.Time `gorm:"type:datetime(3)" json:"lock_lost_time"`                                   // 锁丢失的时间
+	Result          string     `json:"result"`                                                                   // 任务执行结果，根据是否有错误输出进行标记；0 表示执行出错；1 表示执行成功
+	JobID           int        `json:"job_id"`                                                                   // 默认外键，任务 id
 }
@@ -0,0 +1,13 @@
+package model
+
+import "gorm.io/gorm"
+
+// Secret 密钥，值使用配置的 key 加密后保存，接口不返回密钥值
+type Secret struct {
+	gorm.Model
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"type:varchar(64);uniqueIndex;not null" json:"name"` // 密钥名，同时作为注入任务的环境变量名
+	Value       string `gorm:"type:text;not null" json:"-"`                       // 加密后的密钥值
+	Description string `gorm:"type:varchar(255)" json:"description"`              // 描述
+	UserID      int    `json:"user_id"`                                           // 修改人
+}